@@ -3,14 +3,22 @@ module github.com/defektive/requrse
 go 1.24.0
 
 require (
+	github.com/andybalholm/brotli v1.1.1
+	github.com/andybalholm/cascadia v1.3.3
+	github.com/antchfx/htmlquery v1.3.4
 	github.com/gorilla/websocket v1.5.3
 	github.com/itchyny/gojq v0.12.19
+	github.com/klauspost/compress v1.18.0
 	github.com/spf13/cobra v1.10.2
+	golang.org/x/net v0.38.0
 	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
+	github.com/antchfx/xpath v1.3.3 // indirect
+	github.com/golang/groupcache v0.0.0-20210331224755-41bb18bfe9da // indirect
 	github.com/inconshreveable/mousetrap v1.1.0 // indirect
 	github.com/itchyny/timefmt-go v0.1.8 // indirect
 	github.com/spf13/pflag v1.0.10 // indirect
+	golang.org/x/text v0.23.0 // indirect
 )
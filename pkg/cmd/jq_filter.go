@@ -0,0 +1,122 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/itchyny/gojq"
+)
+
+// jqFilter is the compiled --jq output filter.
+type jqFilter struct {
+	code *gojq.Code
+	vars []any
+	// raw writes string results without JSON quoting, like jq -r.
+	raw bool
+}
+
+// newJQFilter compiles expr. args and jsonArgs are name=value pairs from
+// --arg and --argjson, available as $name and in $ARGS.named like in jq.
+func newJQFilter(expr string, args, jsonArgs []string, raw bool) (*jqFilter, error) {
+	query, err := gojq.Parse(expr)
+	if err != nil {
+		return nil, fmt.Errorf("parsing --jq filter: %w", err)
+	}
+
+	named := map[string]any{}
+	names := []string{}
+	vars := []any{}
+	addVar := func(name string, value any) {
+		named[name] = value
+		names = append(names, "$"+name)
+		vars = append(vars, value)
+	}
+
+	for _, arg := range args {
+		name, value, ok := strings.Cut(arg, "=")
+		if !ok {
+			return nil, fmt.Errorf("--arg %q must be name=value", arg)
+		}
+		addVar(name, value)
+	}
+	for _, arg := range jsonArgs {
+		name, value, ok := strings.Cut(arg, "=")
+		if !ok {
+			return nil, fmt.Errorf("--argjson %q must be name=value", arg)
+		}
+		var decoded any
+		if err := json.Unmarshal([]byte(value), &decoded); err != nil {
+			return nil, fmt.Errorf("--argjson %s: %w", name, err)
+		}
+		addVar(name, decoded)
+	}
+
+	names = append(names, "$ARGS")
+	vars = append(vars, map[string]any{"named": named, "positional": []any{}})
+
+	code, err := gojq.Compile(query, gojq.WithVariables(names))
+	if err != nil {
+		return nil, fmt.Errorf("compiling --jq filter: %w", err)
+	}
+
+	return &jqFilter{code: code, vars: vars, raw: raw}, nil
+}
+
+// Apply runs the filter against every JSON value in body and returns all
+// results, one per line. A filter error stops at the results so far and is
+// returned with them.
+func (f *jqFilter) Apply(body []byte) ([]byte, error) {
+	var out bytes.Buffer
+	results := func() []byte {
+		return bytes.TrimSuffix(out.Bytes(), []byte("\n"))
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	for {
+		var input any
+		if err := decoder.Decode(&input); err != nil {
+			if errors.Is(err, io.EOF) {
+				return results(), nil
+			}
+			return results(), fmt.Errorf("--jq input is not JSON: %w", err)
+		}
+
+		halted, err := f.run(&out, input)
+		if err != nil || halted {
+			return results(), err
+		}
+	}
+}
+
+func (f *jqFilter) run(out *bytes.Buffer, input any) (bool, error) {
+	iter := f.code.Run(input, f.vars...)
+	for {
+		v, ok := iter.Next()
+		if !ok {
+			return false, nil
+		}
+
+		if err, ok := v.(error); ok {
+			var halt *gojq.HaltError
+			if errors.As(err, &halt) && halt.Value() == nil {
+				return true, nil
+			}
+			return false, fmt.Errorf("--jq: %w", err)
+		}
+
+		if s, ok := v.(string); ok && f.raw {
+			out.WriteString(s)
+		} else {
+			encoded, err := gojq.Marshal(v)
+			if err != nil {
+				return false, err
+			}
+			out.Write(encoded)
+		}
+		out.WriteByte('\n')
+	}
+}
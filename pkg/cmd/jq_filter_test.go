@@ -135,3 +135,89 @@ func TestJQFilterWithEmptyFilter(t *testing.T) {
 		}
 	})
 }
+
+func TestJQFilterApply(t *testing.T) {
+	tests := []struct {
+		name     string
+		filter   string
+		body     string
+		raw      bool
+		expected string
+	}{
+		{"every result", `.items[]`, `{"items": [1, 2, 3]}`, false, "1\n2\n3"},
+		{"top-level array", `.[] | .name`, `[{"name": "foo"}, {"name": "bar"}]`, false, "\"foo\"\n\"bar\""},
+		{"raw strings", `.[] | .name`, `[{"name": "foo"}, {"name": "bar"}]`, true, "foo\nbar"},
+		{"raw leaves other values as JSON", `.`, `{"a": "<b>"}`, true, `{"a":"<b>"}`},
+		{"scalar body", `. + 1`, `41`, false, "42"},
+		{"several values", `.id`, "{\"id\": 1}\n{\"id\": 2}", false, "1\n2"},
+		{"null result", `.missing`, `{}`, false, "null"},
+		{"halt", `1, halt, 2`, `{}`, false, "1"},
+		{"no results", `empty`, `{}`, false, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f, err := newJQFilter(test.filter, nil, nil, test.raw)
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+
+			out, err := f.Apply([]byte(test.body))
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if string(out) != test.expected {
+				t.Errorf("Expected %q, got %q", test.expected, out)
+			}
+		})
+	}
+}
+
+func TestJQFilterArgs(t *testing.T) {
+	f, err := newJQFilter(`[.[] | select(.role == $role and .level >= $min)] | length, $ARGS.named.role`,
+		[]string{"role=admin"}, []string{"min=2"}, true)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	out, err := f.Apply([]byte(`[{"role": "admin", "level": 3}, {"role": "admin", "level": 1}, {"role": "user", "level": 5}]`))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if string(out) != "1\nadmin" {
+		t.Errorf("Expected %q, got %q", "1\nadmin", out)
+	}
+
+	if _, err := newJQFilter(`.`, []string{"novalue"}, nil, false); err == nil {
+		t.Error("Expected error for --arg without a value")
+	}
+	if _, err := newJQFilter(`.`, nil, []string{"x={bad"}, false); err == nil {
+		t.Error("Expected error for invalid --argjson")
+	}
+}
+
+func TestJQFilterErrors(t *testing.T) {
+	if _, err := newJQFilter(`.[`, nil, nil, false); err == nil {
+		t.Error("Expected error for invalid filter")
+	}
+	if _, err := newJQFilter(`$undefined`, nil, nil, false); err == nil {
+		t.Error("Expected error for undefined variable")
+	}
+
+	f, err := newJQFilter(`.[] | .a`, nil, nil, false)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	out, err := f.Apply([]byte(`[{"a": 1}, "text"]`))
+	if err == nil {
+		t.Error("Expected error indexing a string")
+	}
+	if string(out) != "1" {
+		t.Errorf("Expected results before the error to be kept, got %q", out)
+	}
+
+	if _, err := f.Apply([]byte(`<html>`)); err == nil {
+		t.Error("Expected error for non-JSON body")
+	}
+}
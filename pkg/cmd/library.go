@@ -0,0 +1,71 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+
+	"github.com/defektive/requrse/pkg/request"
+	"github.com/spf13/cobra"
+)
+
+var listCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the templates in --templates-dir",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		library, err := loadLibrary(cmd)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "NAME\tDESCRIPTION\tFILE")
+		for _, tr := range library.Templates() {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", tr.Name, tr.Description, tr.File)
+		}
+		w.Flush()
+	},
+}
+
+var runCmd = &cobra.Command{
+	Use:   "run <name>",
+	Short: "Run a template from --templates-dir by name",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		library, err := loadLibrary(cmd)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		req, err := library.Get(args[0])
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if err := runTemplate(cmd, req); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+// loadLibrary loads the templates in --templates-dir and the --template
+// file.
+func loadLibrary(cmd *cobra.Command) (*request.Library, error) {
+	dirs, _ := cmd.Flags().GetStringSlice("templates-dir")
+	if template, _ := cmd.Flags().GetString("template"); template != "" {
+		dirs = append(dirs, template)
+	}
+	if len(dirs) == 0 {
+		return nil, errors.New("no templates, set --templates-dir or REQURSE_TEMPLATES_DIR")
+	}
+
+	return request.LoadLibrary(dirs...)
+}
+
+func init() {
+	rootCmd.AddCommand(listCmd)
+	rootCmd.AddCommand(runCmd)
+}
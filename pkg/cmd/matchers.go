@@ -0,0 +1,48 @@
+package cmd
+
+import (
+	"github.com/defektive/requrse/pkg/request"
+	"github.com/spf13/cobra"
+)
+
+// addMatcherFlags registers the ffuf style flags for a matcher, e.g. --mc,
+// --ms, --mr for prefix "m" and --fc, --fs, --fr for prefix "f".
+func addMatcherFlags(prefix, name, desc string) {
+	flags := rootCmd.PersistentFlags()
+	flags.StringSlice(prefix+"c", []string{}, desc+" status codes (200,302,500-599)")
+	flags.StringSlice(prefix+"s", []string{}, desc+" response sizes in bytes")
+	flags.StringSlice(prefix+"w", []string{}, desc+" word counts")
+	flags.StringSlice(prefix+"l", []string{}, desc+" line counts")
+	flags.StringArray(prefix+"r", []string{}, desc+" regex (headers and body)")
+	flags.StringArray(prefix+"j", []string{}, desc+" jq expression")
+	flags.String(prefix+"t", "", desc+" response time (>500ms, <1s)")
+	flags.String(prefix+"mode", "or", name+" mode: or, and")
+}
+
+// matcherFromFlags builds a matcher from the flags registered by
+// addMatcherFlags.
+func matcherFromFlags(cmd *cobra.Command, prefix string) (*request.Matcher, error) {
+	flags := cmd.Flags()
+	m := &request.Matcher{}
+
+	for flag, target := range map[string]*request.IntRanges{
+		prefix + "c": &m.Status,
+		prefix + "s": &m.Size,
+		prefix + "w": &m.Words,
+		prefix + "l": &m.Lines,
+	} {
+		values, _ := flags.GetStringSlice(flag)
+		ranges, err := request.ParseIntRanges(values)
+		if err != nil {
+			return nil, err
+		}
+		*target = ranges
+	}
+
+	m.Regex, _ = flags.GetStringArray(prefix + "r")
+	m.JQ, _ = flags.GetStringArray(prefix + "j")
+	m.Time, _ = flags.GetString(prefix + "t")
+	m.Mode, _ = flags.GetString(prefix + "mode")
+
+	return m, m.Compile()
+}
@@ -0,0 +1,41 @@
+package cmd
+
+import (
+	"os"
+
+	"github.com/defektive/requrse/pkg/profile"
+	"github.com/spf13/cobra"
+)
+
+// loadProfile loads the --profile from --profiles-dir, nil when no profile is
+// selected.
+func loadProfile(cmd *cobra.Command) (*profile.Profile, error) {
+	name, _ := cmd.Flags().GetString("profile")
+	if name == "" {
+		return nil, nil
+	}
+	dir, _ := cmd.Flags().GetString("profiles-dir")
+	return profile.Load(dir, name)
+}
+
+// applyProfile fills in the settings of prof that were not set with flags.
+// Flags win over the profile, which wins over the template.
+func applyProfile(cmd *cobra.Command, prof *profile.Profile, host, auth, proxy *string) {
+	flags := cmd.Flags()
+	if prof.Host != "" && !flags.Changed("host") {
+		*host = prof.Host
+	}
+	if prof.Auth != "" && !flags.Changed("auth") {
+		*auth = prof.Auth
+	}
+	if prof.Proxy != "" && !flags.Changed("proxy") {
+		*proxy = prof.Proxy
+	}
+}
+
+func envOr(name, fallback string) string {
+	if value, ok := os.LookupEnv(name); ok {
+		return value
+	}
+	return fallback
+}
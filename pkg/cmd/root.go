@@ -1,15 +1,17 @@
 package cmd
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"os"
 	"path/filepath"
 	"strings"
 
+	"github.com/defektive/requrse/pkg/output"
+	"github.com/defektive/requrse/pkg/profile"
 	"github.com/defektive/requrse/pkg/request"
-	"github.com/itchyny/gojq"
 	"github.com/spf13/cobra"
 )
 
@@ -23,121 +25,317 @@ var rootCmd = &cobra.Command{
 	// Uncomment the following line if your bare application
 	// has an action associated with it:
 	Run: func(cmd *cobra.Command, args []string) {
-		host, _ := cmd.Flags().GetString("host")
-		auth, _ := cmd.Flags().GetString("auth")
 		template, _ := cmd.Flags().GetString("template")
-		outputDir, _ := cmd.Flags().GetString("out")
-		ext, _ := cmd.Flags().GetString("ext")
-		extra, _ := cmd.Flags().GetStringSlice("extra")
-		lists, _ := cmd.Flags().GetStringSlice("list")
-		mode, _ := cmd.Flags().GetString("mode")
-		proxy, _ := cmd.Flags().GetString("proxy")
-
-		req, err := request.FromFile(template)
+
+		templates, err := request.FromFileAll(template)
 		if err != nil {
-			panic(err)
+			log.Fatal(err)
+		}
+		if len(templates) == 0 {
+			log.Fatalf("%s holds no template", template)
+		}
+		if len(templates) > 1 {
+			log.Fatalf("%s holds %d templates, pick one with requrse run <name> -t %s", template, len(templates), template)
 		}
 
-		if proxy != "" {
-			err = req.SetProxy(proxy)
-			if err != nil {
-				log.Fatal(err)
-			}
-			if debug {
-				log.Printf("Proxy: %s", proxy)
-			}
+		if err := runTemplate(cmd, templates[0]); err != nil {
+			log.Fatal(err)
 		}
+	},
+}
 
-		extraData := map[string]interface{}{}
+// runTemplate runs req with the settings of the command line flags. Errors
+// of the run are returned once the output is flushed.
+func runTemplate(cmd *cobra.Command, req *request.TemplateRequest) error {
+	host, _ := cmd.Flags().GetString("host")
+	auth, _ := cmd.Flags().GetString("auth")
+	outputDir, _ := cmd.Flags().GetString("out")
+	ext, _ := cmd.Flags().GetString("ext")
+	extra, _ := cmd.Flags().GetStringSlice("extra")
+	lists, _ := cmd.Flags().GetStringSlice("list")
+	mode, _ := cmd.Flags().GetString("mode")
+	proxy, _ := cmd.Flags().GetString("proxy")
+	outName, _ := cmd.Flags().GetString("out-name")
+	outShard, _ := cmd.Flags().GetInt("out-shard")
+	outMeta, _ := cmd.Flags().GetBool("out-meta")
+	outMetaRequest, _ := cmd.Flags().GetBool("out-meta-request")
+	outOriginal, _ := cmd.Flags().GetBool("out-original")
+	checkpoint, _ := cmd.Flags().GetString("checkpoint")
+	checkpointEvery, _ := cmd.Flags().GetInt("checkpoint-every")
+	resume, _ := cmd.Flags().GetString("resume")
+	baseline, _ := cmd.Flags().GetInt("baseline")
+	maxBodySize, _ := cmd.Flags().GetString("max-body-size")
+	onOversize, _ := cmd.Flags().GetString("on-oversize")
+	streamPrefix, _ := cmd.Flags().GetString("stream-prefix")
+	outStream, _ := cmd.Flags().GetBool("out-stream")
+	filter, _ := cmd.Flags().GetString("jq")
+	maxDuration, _ := cmd.Flags().GetDuration("max-duration")
+	httpVersion, _ := cmd.Flags().GetString("http-version")
+	redirects, _ := cmd.Flags().GetString("redirects")
+	history, _ := cmd.Flags().GetInt("history")
+	stopOnRepeat, _ := cmd.Flags().GetBool("stop-on-repeat")
+	collect, _ := cmd.Flags().GetString("collect")
+	collectFormat, _ := cmd.Flags().GetString("collect-format")
+	maxRedirects, _ := cmd.Flags().GetInt("max-redirects")
+	retries, _ := cmd.Flags().GetInt("retries")
+	retryDelay, _ := cmd.Flags().GetDuration("retry-delay")
+	retryStatus, _ := cmd.Flags().GetIntSlice("retry-status")
+	middleware, _ := cmd.Flags().GetStringSlice("middleware")
 
-		for _, value := range extra {
-			i := strings.Index(value, "=")
-			extraData[value[:i]] = value[i+1:]
+	prof, err := loadProfile(cmd)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if prof != nil {
+		applyProfile(cmd, prof, &host, &auth, &proxy)
+		req.TLS = req.TLS.Merge(prof.TLS)
+		if debug {
+			log.Printf("Profile: %s", prof.File)
 		}
+	}
+
+	matchRules, err := matcherFromFlags(cmd, "m")
+	if err != nil {
+		log.Fatal(err)
+	}
+	filterRules, err := matcherFromFlags(cmd, "f")
+	if err != nil {
+		log.Fatal(err)
+	}
 
-		c := &request.RequestContext{
-			Host:      host,
-			AuthToken: auth,
-			Extra:     extraData,
+	if proxy != "" {
+		err = req.SetProxy(proxy)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if debug {
+			log.Printf("Proxy: %s", proxy)
 		}
+	}
 
-		if outputDir != "" {
-			err = os.MkdirAll(outputDir, 0755)
-			if err != nil {
-				panic(err)
-			}
+	if baseline > 0 {
+		if req.Baseline == nil {
+			req.Baseline = &request.Baseline{}
 		}
+		req.Baseline.Samples = baseline
+	}
 
-		if len(lists) > 0 {
-			if mode == "pitchfork" {
-				for _, list := range lists {
-					fileBytes, err := os.ReadFile(filepath.Join(list))
-					if err != nil {
-						panic(err)
-					}
-					req.Lists = append(req.Lists, strings.Split(string(fileBytes), "\n"))
-				}
-			}
+	if history > 0 {
+		req.History = history
+	}
+	if stopOnRepeat {
+		req.StopOnRepeat = true
+	}
+
+	if httpVersion != "" {
+		req.Connection.HTTPVersion = httpVersion
+	}
+
+	switch redirects {
+	case "":
+	case "follow":
+		follow := true
+		req.Redirects.Follow = &follow
+	case "none":
+		follow := false
+		req.Redirects.Follow = &follow
+	case "same-host":
+		req.Redirects.SameHost = true
+	default:
+		log.Fatalf("invalid --redirects %q, expected follow, none or same-host", redirects)
+	}
+	if maxRedirects > 0 {
+		req.Redirects.Max = maxRedirects
+	}
+
+	if retries > 0 {
+		req.Retry.Max = retries
+	}
+	if retryDelay > 0 {
+		req.Retry.Delay = request.Duration(retryDelay)
+	}
+	if len(retryStatus) > 0 {
+		req.Retry.Status = retryStatus
+	}
+
+	for _, name := range middleware {
+		req.Middleware = append(req.Middleware, request.MiddlewareConfig{Name: name})
+	}
+
+	if maxBodySize != "" {
+		if req.MaxBodySize, err = request.ParseByteSize(maxBodySize); err != nil {
+			log.Fatal(err)
 		}
+	}
+	if onOversize != "" {
+		req.OnOversize = onOversize
+	}
+	if streamPrefix != "" {
+		if req.StreamPrefix, err = request.ParseByteSize(streamPrefix); err != nil {
+			log.Fatal(err)
+		}
+	}
 
-		iteration := 0
-		req.Recurse(c, func(body []byte) {
-			if debug {
-				log.Println("handle response", string(body))
+	if !matchRules.IsEmpty() {
+		req.Match = matchRules
+	}
+	if !filterRules.IsEmpty() {
+		req.Filter = filterRules
+	}
+
+	extraData := map[string]interface{}{}
+	if prof != nil {
+		for key, value := range prof.Extra {
+			extraData[key] = value
+		}
+	}
+
+	for _, value := range extra {
+		i := strings.Index(value, "=")
+		extraData[value[:i]] = value[i+1:]
+	}
+
+	c := &request.RequestContext{
+		Host:      host,
+		AuthToken: auth,
+		Extra:     extraData,
+	}
+
+	var out *output.Writer
+	if outputDir != "" {
+		out, err = output.NewWriter(outputDir, outName, ext)
+		if err != nil {
+			log.Fatal(err)
+		}
+		out.ShardSize = outShard
+		out.Meta = outMeta
+		out.MetaRequest = outMetaRequest
+		out.Original = outOriginal
+		if outOriginal {
+			req.KeepOriginalBody = true
+		}
+		// the jq filter and collecting need the whole body, so only
+		// unfiltered output is streamed to disk
+		if outStream && filter == "" && collect == "" && req.Collect == "" {
+			req.BodySink = out
+		}
+	}
+
+	var jq *jqFilter
+	if filter != "" {
+		jqArgs, _ := cmd.Flags().GetStringArray("arg")
+		jqJSONArgs, _ := cmd.Flags().GetStringArray("argjson")
+		rawOutput, _ := cmd.Flags().GetBool("raw-output")
+		jq, err = newJQFilter(filter, jqArgs, jqJSONArgs, rawOutput)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	var collector *output.Collector
+	if collect != "" {
+		if req.Collect == "" {
+			// collect whole bodies
+			req.Collect = "."
+		}
+		if resume != "" {
+			collector, err = output.ResumeCollector(collect, collectFormat, req.CollectItem)
+		} else {
+			collector, err = output.NewCollector(collect, collectFormat)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer func() {
+			if err := collector.Close(); err != nil {
+				log.Println(err)
 			}
+		}()
+	}
 
-			filter, _ := cmd.Flags().GetString("jq")
-			if filter != "" {
-				query, err := gojq.Parse(filter)
+	if len(lists) > 0 {
+		if mode == "pitchfork" {
+			for _, list := range lists {
+				fileBytes, err := os.ReadFile(filepath.Join(list))
 				if err != nil {
-					log.Println(err)
-					panic(err)
+					log.Fatal(err)
 				}
+				req.Lists = append(req.Lists, strings.Split(strings.TrimRight(string(fileBytes), "\n"), "\n"))
+			}
+		}
+	}
 
-				r := map[string]any{}
-				jsonBytes := []byte(string(body))
-				json.Unmarshal(jsonBytes, &r)
-
-				resultBytes := []byte{}
-
-				iter := query.Run(r)
-				for {
-					v, ok := iter.Next()
-					if !ok {
-						break
-					}
-					if err, ok := v.(error); ok {
-						if err, ok := err.(*gojq.HaltError); ok && err.Value() == nil {
-							break
-						}
-						log.Println(err)
-						panic(err)
-					}
-
-					if v != nil {
-						resultBytes, _ = json.Marshal(v)
-						break
-					}
-				}
+	if resume != "" {
+		cp, err := request.LoadCheckpoint(resume)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := req.Resume(c, cp); err != nil {
+			log.Fatal(err)
+		}
+		if checkpoint == "" {
+			checkpoint = resume
+		}
+		if debug {
+			log.Printf("Resuming from iteration %d", cp.Iteration)
+		}
+	}
+	req.CheckpointFile = checkpoint
+	req.CheckpointEvery = checkpointEvery
 
-				body = resultBytes
+	ctx := context.Background()
+	if maxDuration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, maxDuration, errors.New("--max-duration reached"))
+		defer cancel()
+	}
+
+	runner := request.NewRunner(request.WithHooks(request.Hooks{
+		OnRetry: func(ctx context.Context, c *request.RequestContext, attempt int, err error) {
+			if debug {
+				log.Printf("retrying iteration %d after attempt %d: %v", c.Iteration, attempt, err)
 			}
+		},
+	}))
+	for res := range runner.All(ctx, req, c) {
+		if res.Err != nil {
+			return res.Err
+		}
+		if !res.Reported {
+			continue
+		}
 
-			if outputDir != "" {
-				err := os.WriteFile(filepath.Join(outputDir, fmt.Sprintf("response-%d.%s", iteration, ext)), body, 0644)
-				if err != nil {
-					log.Println(err)
-				}
-			} else {
-				if len(body) > 0 {
-					fmt.Println(string(body))
-				}
+		body := res.Body
+		if debug {
+			log.Println("handle response", string(body))
+		}
+
+		if jq != nil {
+			filtered, err := jq.Apply(body)
+			if err != nil {
+				log.Println(err)
 			}
-			iteration++
-		})
+			body = filtered
+		}
 
-		//log.Println(iteration)
-	},
+		if collector != nil {
+			if err := collector.Add(res.Response.Collected); err != nil {
+				log.Println(err)
+			}
+		}
+
+		if out != nil {
+			err := out.Write(c, res.Response, body)
+			if err != nil {
+				log.Println(err)
+			}
+		} else if collector == nil {
+			if len(body) > 0 {
+				fmt.Println(string(body))
+			}
+		}
+	}
+	return nil
 }
 
 // Execute adds all child commands to the root command and sets flags appropriately.
@@ -152,15 +350,49 @@ func Execute() {
 func init() {
 	rootCmd.PersistentFlags().BoolVarP(&debug, "debug", "d", debug, "debug mode")
 	rootCmd.PersistentFlags().StringP("template", "t", "", "Template to process")
+	rootCmd.PersistentFlags().StringSlice("templates-dir", filepath.SplitList(os.Getenv("REQURSE_TEMPLATES_DIR")), "directories of templates for list and run")
 	rootCmd.PersistentFlags().StringP("host", "H", "localhost", "http host")
 	rootCmd.PersistentFlags().StringP("auth", "a", "", "auth token")
+	rootCmd.PersistentFlags().String("profile", os.Getenv("REQURSE_PROFILE"), "environment profile to load host, auth, proxy, TLS and extra data from, e.g. staging")
+	rootCmd.PersistentFlags().String("profiles-dir", envOr("REQURSE_PROFILES_DIR", profile.DefaultDir), "directory of profiles")
 	rootCmd.PersistentFlags().StringP("out", "o", "", "output directory")
 	rootCmd.PersistentFlags().String("ext", "json", "extension for files in output directory")
+	rootCmd.PersistentFlags().String("out-name", output.DefaultNameTemplate, "Go template for output file names")
+	rootCmd.PersistentFlags().Int("out-shard", 0, "max files per output subdirectory (0 disables sharding)")
+	rootCmd.PersistentFlags().Bool("out-meta", false, "write a .meta.json sidecar with status and headers next to each response")
+	rootCmd.PersistentFlags().Bool("out-meta-request", false, "add the rendered request, with credentials redacted, to --out-meta sidecars")
+	rootCmd.PersistentFlags().Bool("out-original", false, "also save bodies as received, before decompression and charset conversion, as .orig files")
 	rootCmd.PersistentFlags().StringSliceP("extra", "e", []string{}, "extra data (-e something=someval)")
 	rootCmd.PersistentFlags().StringSliceP("list", "l", []string{}, "list files (-l wordlist-01 -l wordlist-02)")
 
 	rootCmd.PersistentFlags().StringP("mode", "m", "", "Mode for list usage. Currently only Pitchfork")
 	rootCmd.PersistentFlags().StringP("proxy", "p", "", "proxy to use")
-	rootCmd.PersistentFlags().String("jq", "", "jq filter to apply to JSON output")
+	rootCmd.PersistentFlags().StringP("jq", "j", "", "jq filter to apply to JSON output, every result is written on its own line")
+	rootCmd.PersistentFlags().BoolP("raw-output", "r", false, "write string results of --jq without quotes")
+	rootCmd.PersistentFlags().StringArray("arg", []string{}, "string variable for --jq (--arg name=value)")
+	rootCmd.PersistentFlags().StringArray("argjson", []string{}, "JSON variable for --jq (--argjson name='{\"a\": 1}')")
+	rootCmd.PersistentFlags().String("checkpoint", "", "file to periodically save run progress to")
+	rootCmd.PersistentFlags().Int("checkpoint-every", request.DefaultCheckpointEvery, "iterations between checkpoint writes")
+	rootCmd.PersistentFlags().String("resume", "", "checkpoint file to resume a previous run from")
+	rootCmd.PersistentFlags().Int("baseline", 0, "learn a baseline from the first N responses and score the rest for anomalies")
+	rootCmd.PersistentFlags().String("collect", "", "write the items of the template's collect expression from every response to one file (- for stdout)")
+	rootCmd.PersistentFlags().String("collect-format", "", "json (one array) or ndjson, picked from the --collect extension by default")
+	rootCmd.PersistentFlags().Int("history", 0, "number of previous responses kept for templates (.History) and conditions ($history)")
+	rootCmd.PersistentFlags().Bool("stop-on-repeat", false, "stop a paginated run when a response body repeats (ignored with lists)")
+	rootCmd.PersistentFlags().String("http-version", "", "HTTP version: auto, 1.1, 2 or h2c")
+	rootCmd.PersistentFlags().String("redirects", "", "redirect policy: follow, none or same-host")
+	rootCmd.PersistentFlags().Int("max-redirects", 0, "max redirects to follow (default 10)")
+	rootCmd.PersistentFlags().Int("retries", 0, "times to resend a request that fails or gets a --retry-status")
+	rootCmd.PersistentFlags().Duration("retry-delay", 0, "wait before the first retry, doubled after each one")
+	rootCmd.PersistentFlags().IntSlice("retry-status", []int{}, "response statuses to retry (--retry-status 429,503)")
+	rootCmd.PersistentFlags().StringSlice("middleware", []string{}, "middleware to send HTTP requests through, e.g. log, dump or cache")
+	rootCmd.PersistentFlags().Duration("max-duration", 0, "stop the run after this long, e.g. 30m (a checkpoint is saved to resume from)")
+	rootCmd.PersistentFlags().String("max-body-size", "", "max response body size to read, e.g. 10MB")
+	rootCmd.PersistentFlags().String("on-oversize", "", "what to do with bodies over --max-body-size: truncate (default) or abort")
+	rootCmd.PersistentFlags().Bool("out-stream", false, "stream bodies straight to the output directory instead of holding them in memory (conditions and matchers only see --stream-prefix)")
+	rootCmd.PersistentFlags().String("stream-prefix", "", "how much of a streamed body conditions and matchers see (default 1MB)")
+
+	addMatcherFlags("m", "match", "only report responses matching")
+	addMatcherFlags("f", "filter", "drop responses matching")
 
 }
@@ -0,0 +1,193 @@
+package output
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/defektive/requrse/pkg/request"
+)
+
+// Collect output formats. FormatJSON writes one JSON array, FormatNDJSON
+// one item per line.
+const (
+	FormatJSON   = "json"
+	FormatNDJSON = "ndjson"
+)
+
+// Collector streams the items collected from every response into a single
+// file as they arrive.
+type Collector struct {
+	format string
+	file   io.WriteCloser
+	w      *bufio.Writer
+	count  int
+	seen   map[string]bool
+}
+
+// NewCollector creates path, or writes to stdout for "-". An empty format
+// is picked from the extension: .ndjson and .jsonl are NDJSON, anything else
+// a JSON array.
+func NewCollector(path, format string) (*Collector, error) {
+	format, err := collectFormat(path, format)
+	if err != nil {
+		return nil, err
+	}
+
+	var file io.WriteCloser = os.Stdout
+	if path != "-" {
+		f, err := os.Create(path)
+		if err != nil {
+			return nil, err
+		}
+		file = f
+	}
+
+	return &Collector{
+		format: format,
+		file:   file,
+		w:      bufio.NewWriter(file),
+		seen:   map[string]bool{},
+	}, nil
+}
+
+// ResumeCollector is NewCollector for a resumed run. Items already in path
+// are kept and item wraps them with their keys, so items collected again
+// are skipped. A JSON array left open by a run that died is closed.
+func ResumeCollector(path, format string, item func(any) request.CollectedItem) (*Collector, error) {
+	format, err := collectFormat(path, format)
+	if err != nil {
+		return nil, err
+	}
+	if path == "-" {
+		return NewCollector(path, format)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	values, err := readCollected(data, format)
+	if err != nil {
+		return nil, fmt.Errorf("reading collected items from %s: %w", path, err)
+	}
+
+	c, err := NewCollector(path, format)
+	if err != nil {
+		return nil, err
+	}
+	items := make([]request.CollectedItem, len(values))
+	for i, v := range values {
+		items[i] = item(v)
+	}
+	if err := c.Add(items); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// collectFormat picks the format for path when format is empty: .ndjson
+// and .jsonl are NDJSON, anything else a JSON array.
+func collectFormat(path, format string) (string, error) {
+	if format == "" {
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".ndjson", ".jsonl":
+			format = FormatNDJSON
+		default:
+			format = FormatJSON
+		}
+	}
+	if format != FormatJSON && format != FormatNDJSON {
+		return "", fmt.Errorf("unknown collect format %q, expected json or ndjson", format)
+	}
+	return format, nil
+}
+
+func readCollected(data []byte, format string) ([]any, error) {
+	var values []any
+	if format == FormatNDJSON {
+		dec := json.NewDecoder(bytes.NewReader(data))
+		for {
+			var v any
+			if err := dec.Decode(&v); err == io.EOF {
+				return values, nil
+			} else if err != nil {
+				return nil, err
+			}
+			values = append(values, v)
+		}
+	}
+
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 {
+		return nil, nil
+	}
+	if err := json.Unmarshal(data, &values); err != nil {
+		// the array of a run that died was never closed
+		if json.Unmarshal(append(data, "\n]"...), &values) != nil {
+			return nil, err
+		}
+	}
+	return values, nil
+}
+
+// Add writes items, skipping any with a key that was added before. Items are
+// flushed right away so a run that dies keeps what it collected.
+func (c *Collector) Add(items []request.CollectedItem) error {
+	for _, item := range items {
+		if item.Key != "" {
+			if c.seen[item.Key] {
+				continue
+			}
+			c.seen[item.Key] = true
+		}
+
+		encoded, err := json.Marshal(item.Value)
+		if err != nil {
+			return err
+		}
+
+		if c.format == FormatJSON {
+			sep := ",\n  "
+			if c.count == 0 {
+				sep = "[\n  "
+			}
+			c.w.WriteString(sep)
+		}
+		c.w.Write(encoded)
+		if c.format == FormatNDJSON {
+			c.w.WriteByte('\n')
+		}
+		c.count++
+	}
+	return c.w.Flush()
+}
+
+// Count is the number of items written so far.
+func (c *Collector) Count() int {
+	return c.count
+}
+
+// Close finishes the JSON array, flushes and closes the file.
+func (c *Collector) Close() error {
+	if c.format == FormatJSON {
+		if c.count == 0 {
+			c.w.WriteString("[")
+		}
+		c.w.WriteString("\n]\n")
+	}
+
+	err := c.w.Flush()
+	if c.file != os.Stdout {
+		if closeErr := c.file.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
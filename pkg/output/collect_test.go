@@ -0,0 +1,114 @@
+package output
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/defektive/requrse/pkg/request"
+)
+
+func items(values ...any) []request.CollectedItem {
+	collected := []request.CollectedItem{}
+	for _, v := range values {
+		key, _ := json.Marshal(v.(map[string]any)["id"])
+		collected = append(collected, request.CollectedItem{Value: v, Key: string(key)})
+	}
+	return collected
+}
+
+func TestCollectorJSON(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "items.json")
+	c, err := NewCollector(path, "")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	c.Add(items(map[string]any{"id": 1}, map[string]any{"id": 2}))
+	c.Add(items(map[string]any{"id": 2}, map[string]any{"id": 3}))
+	if err := c.Close(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	data, _ := os.ReadFile(path)
+	var got []map[string]any
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("Expected a JSON array, got %s (%v)", data, err)
+	}
+	if len(got) != 3 || c.Count() != 3 {
+		t.Errorf("Expected three unique items, got %v", got)
+	}
+}
+
+func TestCollectorNDJSON(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "items.jsonl")
+	c, err := NewCollector(path, "")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	c.Add([]request.CollectedItem{{Value: "a"}, {Value: "a"}, {Value: []any{1.0}}})
+	c.Close()
+
+	data, _ := os.ReadFile(path)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	// items without a key are never dropped
+	if len(lines) != 3 || lines[0] != `"a"` || lines[2] != `[1]` {
+		t.Errorf("Expected one item per line, got %q", data)
+	}
+}
+
+func TestCollectorEmpty(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "items.json")
+	c, err := NewCollector(path, FormatJSON)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	c.Close()
+
+	data, _ := os.ReadFile(path)
+	var got []any
+	if err := json.Unmarshal(data, &got); err != nil || len(got) != 0 {
+		t.Errorf("Expected an empty JSON array, got %s (%v)", data, err)
+	}
+
+	if _, err := NewCollector(path, "csv"); err == nil {
+		t.Error("Expected error for unknown format")
+	}
+}
+
+func TestResumeCollector(t *testing.T) {
+	key := func(v any) request.CollectedItem { return items(v)[0] }
+
+	for _, format := range []string{FormatJSON, FormatNDJSON} {
+		t.Run(format, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "items."+format)
+			c, err := NewCollector(path, "")
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			// the run dies without closing the collector
+			c.Add(items(map[string]any{"id": 1}, map[string]any{"id": 2}))
+
+			c, err = ResumeCollector(path, "", key)
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			c.Add(items(map[string]any{"id": 2}, map[string]any{"id": 3}))
+			if err := c.Close(); err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+
+			data, _ := os.ReadFile(path)
+			values, err := readCollected(data, format)
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if len(values) != 3 || c.Count() != 3 {
+				t.Errorf("Expected the items of both runs once, got %s", data)
+			}
+		})
+	}
+}
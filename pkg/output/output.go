@@ -0,0 +1,225 @@
+package output
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/defektive/requrse/pkg/request"
+)
+
+// DefaultNameTemplate matches the historical response-N.ext naming.
+const DefaultNameTemplate = "response-{{.Iteration}}.{{.Ext}}"
+
+// NameData is what the output name template is rendered against. Fields of
+// both the request context and the response are available at the top level,
+// e.g. {{.Page}}-{{index .ListParams 0}}-{{.Status}}.json
+type NameData struct {
+	*request.RequestContext
+	*request.SimpleResponse
+	Ext string
+}
+
+// Meta is written to the .meta.json sidecar next to each saved body.
+type Meta struct {
+	Iteration   int                    `json:"iteration"`
+	Page        int                    `json:"page"`
+	ListParams  []string               `json:"list_params,omitempty"`
+	Request     *request.SimpleRequest `json:"request,omitempty"`
+	Status      int                    `json:"status"`
+	ContentType string                 `json:"content_type"`
+	Headers     map[string][]string    `json:"headers"`
+	Anomaly     *request.Anomaly       `json:"anomaly,omitempty"`
+	Size        int64                  `json:"size"`
+	Truncated   bool                   `json:"truncated,omitempty"`
+	Redirects   []request.Redirect     `json:"redirects,omitempty"`
+	Timing      request.Timing         `json:"timing"`
+	Cookies     []request.Cookie       `json:"cookies,omitempty"`
+	RemoteAddr  string                 `json:"remote_addr,omitempty"`
+	Proto       string                 `json:"proto,omitempty"`
+	TLS         *request.TLSInfo       `json:"tls,omitempty"`
+}
+
+// Writer saves response bodies into a directory.
+type Writer struct {
+	Dir string
+	Ext string
+	// ShardSize splits output into numbered subdirectories holding at most
+	// ShardSize responses each. Zero disables sharding.
+	ShardSize int
+	// Meta writes a .meta.json sidecar with status and headers for each body.
+	Meta bool
+	// MetaRequest adds the request echo to the sidecar. Credentials are
+	// redacted, but the rendered body and URL can still hold secrets.
+	MetaRequest bool
+	// Original also writes the body as received, before decompression and
+	// charset conversion, to a .orig file next to it.
+	Original bool
+
+	nameTemplate *template.Template
+	count        int
+	// streamed is the file Open created for the response being read
+	streamed string
+}
+
+// NewWriter creates the output directory and parses the name template. An
+// empty nameTemplate uses DefaultNameTemplate.
+func NewWriter(dir, nameTemplate, ext string) (*Writer, error) {
+	if nameTemplate == "" {
+		nameTemplate = DefaultNameTemplate
+	}
+
+	tpl, err := template.New("out-name").Parse(nameTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("parsing output name template: %w", err)
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	return &Writer{
+		Dir:          dir,
+		Ext:          ext,
+		nameTemplate: tpl,
+	}, nil
+}
+
+// Path returns the file path the next response would be written to.
+func (w *Writer) Path(c *request.RequestContext, resp *request.SimpleResponse) (string, error) {
+	if resp == nil {
+		resp = &request.SimpleResponse{}
+	}
+
+	var name bytes.Buffer
+	err := w.nameTemplate.Execute(&name, NameData{
+		RequestContext: c,
+		SimpleResponse: resp,
+		Ext:            w.Ext,
+	})
+	if err != nil {
+		return "", fmt.Errorf("rendering output name: %w", err)
+	}
+
+	rel := filepath.Clean(name.String())
+	if !filepath.IsLocal(rel) {
+		return "", fmt.Errorf("output name %q escapes the output directory", name.String())
+	}
+
+	if w.ShardSize > 0 {
+		rel = filepath.Join(fmt.Sprintf("%05d", w.count/w.ShardSize), rel)
+	}
+
+	return filepath.Join(w.Dir, rel), nil
+}
+
+// Open creates the file a streamed response body is written to. It makes
+// Writer a request.BodySink, the name template only sees the request, status
+// and headers at this point.
+func (w *Writer) Open(c *request.RequestContext, resp *request.SimpleResponse) (io.WriteCloser, error) {
+	path, err := w.Path(c, resp)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	w.streamed = path
+	return f, nil
+}
+
+// Discard removes the file of a streamed response that is not reported.
+func (w *Writer) Discard(c *request.RequestContext, resp *request.SimpleResponse) error {
+	if w.streamed == "" {
+		return nil
+	}
+
+	path := w.streamed
+	w.streamed = ""
+	return os.Remove(path)
+}
+
+// Write saves body, and optionally its metadata sidecar, for one response.
+// Bodies that were streamed to disk through Open are already written, only
+// their sidecars are.
+func (w *Writer) Write(c *request.RequestContext, resp *request.SimpleResponse, body []byte) error {
+	path := w.streamed
+	w.streamed = ""
+
+	if resp == nil || !resp.Streamed || path == "" {
+		var err error
+		if path, err = w.Path(c, resp); err != nil {
+			return err
+		}
+
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+
+		if err := os.WriteFile(path, body, 0644); err != nil {
+			return err
+		}
+	}
+	w.count++
+
+	if w.Original && resp != nil && resp.OriginalBody != nil {
+		if err := os.WriteFile(path+".orig", resp.OriginalBody, 0644); err != nil {
+			return err
+		}
+	}
+
+	if !w.Meta {
+		return nil
+	}
+
+	return writeMeta(MetaPath(path), c, resp, w.MetaRequest)
+}
+
+// MetaPath returns the sidecar path for a body saved at path.
+func MetaPath(path string) string {
+	return strings.TrimSuffix(path, filepath.Ext(path)) + ".meta.json"
+}
+
+func writeMeta(path string, c *request.RequestContext, resp *request.SimpleResponse, withRequest bool) error {
+	meta := Meta{
+		Iteration:  c.Iteration,
+		Page:       c.Page,
+		ListParams: c.ListParams,
+	}
+	if resp != nil {
+		if withRequest {
+			redacted := resp.Request.Redact(c.AuthToken)
+			meta.Request = &redacted
+		}
+		meta.Status = resp.Status
+		meta.ContentType = resp.ContentType
+		meta.Headers = resp.Headers
+		meta.Anomaly = resp.Anomaly
+		meta.Size = resp.Size
+		meta.Truncated = resp.Truncated
+		meta.Redirects = resp.Redirects
+		meta.Timing = resp.Timing
+		meta.Cookies = resp.Cookies
+		meta.RemoteAddr = resp.RemoteAddr
+		meta.Proto = resp.Proto
+		meta.TLS = resp.TLS
+	}
+
+	metaBytes, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, metaBytes, 0644)
+}
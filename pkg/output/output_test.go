@@ -0,0 +1,159 @@
+package output
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/defektive/requrse/pkg/request"
+)
+
+func TestWriterDefaultName(t *testing.T) {
+	dir := t.TempDir()
+	w, err := NewWriter(dir, "", "json")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	c := &request.RequestContext{Iteration: 3}
+	if err := w.Write(c, &request.SimpleResponse{}, []byte(`{}`)); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "response-3.json")); err != nil {
+		t.Errorf("Expected response-3.json to exist: %v", err)
+	}
+}
+
+func TestWriterNameTemplate(t *testing.T) {
+	dir := t.TempDir()
+	w, err := NewWriter(dir, "{{.Page}}-{{index .ListParams 0}}-{{.Status}}.{{.Ext}}", "json")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	c := &request.RequestContext{Page: 2, ListParams: []string{"admin"}}
+	path, err := w.Path(c, &request.SimpleResponse{Status: 302})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	expected := filepath.Join(dir, "2-admin-302.json")
+	if path != expected {
+		t.Errorf("Expected %s, got %s", expected, path)
+	}
+}
+
+func TestWriterNameEscapes(t *testing.T) {
+	w, err := NewWriter(t.TempDir(), "../{{.Page}}.json", "json")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if _, err := w.Path(&request.RequestContext{Page: 1}, nil); err == nil {
+		t.Error("Expected error for name outside output directory")
+	}
+}
+
+func TestWriterShardAndMeta(t *testing.T) {
+	dir := t.TempDir()
+	w, err := NewWriter(dir, "", "json")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	w.ShardSize = 2
+	w.Meta = true
+
+	for i := 0; i < 3; i++ {
+		c := &request.RequestContext{Iteration: i}
+		resp := &request.SimpleResponse{Status: 200, Headers: map[string][]string{"X-Test": {"yes"}}}
+		if err := w.Write(c, resp, []byte(`{}`)); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+	}
+
+	for _, name := range []string{"00000/response-0.json", "00000/response-1.json", "00001/response-2.json"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("Expected %s to exist: %v", name, err)
+		}
+	}
+
+	meta, err := os.ReadFile(filepath.Join(dir, "00001", "response-2.meta.json"))
+	if err != nil {
+		t.Fatalf("Expected meta sidecar, got %v", err)
+	}
+	if !strings.Contains(string(meta), `"X-Test"`) {
+		t.Errorf("Expected headers in meta, got %s", meta)
+	}
+}
+
+func TestWriterStreamed(t *testing.T) {
+	dir := t.TempDir()
+	w, err := NewWriter(dir, "", "json")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	w.Meta = true
+
+	c := &request.RequestContext{Iteration: 1}
+	resp := &request.SimpleResponse{Status: 200, Streamed: true, Size: 2}
+	f, err := w.Open(c, resp)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	f.Write([]byte(`{}`))
+	f.Close()
+
+	// the body passed to Write is only a prefix, the file keeps the stream
+	if err := w.Write(c, resp, []byte(`{`)); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	saved, err := os.ReadFile(filepath.Join(dir, "response-1.json"))
+	if err != nil || string(saved) != `{}` {
+		t.Errorf("Expected streamed body to be kept, got %q (%v)", saved, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "response-1.meta.json")); err != nil {
+		t.Errorf("Expected meta sidecar for streamed body: %v", err)
+	}
+
+	c = &request.RequestContext{Iteration: 2}
+	f, err = w.Open(c, resp)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	f.Close()
+	if err := w.Discard(c, resp); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "response-2.json")); !os.IsNotExist(err) {
+		t.Errorf("Expected discarded body to be removed, got %v", err)
+	}
+}
+
+func TestWriterMetaRequest(t *testing.T) {
+	dir := t.TempDir()
+	w, err := NewWriter(dir, "", "json")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	w.Meta = true
+
+	resp := &request.SimpleResponse{Status: 200, Request: request.SimpleRequest{URL: "http://example.com/login"}}
+	for i, withRequest := range []bool{false, true} {
+		w.MetaRequest = withRequest
+		if err := w.Write(&request.RequestContext{Iteration: i}, resp, []byte(`{}`)); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		meta, err := os.ReadFile(filepath.Join(dir, fmt.Sprintf("response-%d.meta.json", i)))
+		if err != nil {
+			t.Fatalf("Expected meta sidecar, got %v", err)
+		}
+		if strings.Contains(string(meta), "example.com/login") != withRequest {
+			t.Errorf("Expected request in meta %v, got %s", withRequest, meta)
+		}
+	}
+}
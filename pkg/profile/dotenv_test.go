@@ -0,0 +1,45 @@
+package profile
+
+import "testing"
+
+func TestParseEnv(t *testing.T) {
+	data := []byte(`# staging
+export HOST=api.staging.test
+PORT=8443 # https
+URL=https://${HOST}:${PORT}
+LITERAL='${HOST}'
+QUOTED="line\none \"two\""
+
+EMPTY=
+`)
+
+	vars, err := parseEnv(data, "")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	expected := map[string]string{
+		"HOST":    "api.staging.test",
+		"PORT":    "8443",
+		"URL":     "https://api.staging.test:8443",
+		"LITERAL": "${HOST}",
+		"QUOTED":  "line\none \"two\"",
+		"EMPTY":   "",
+	}
+	if len(vars) != len(expected) {
+		t.Errorf("Expected %d variables, got %v", len(expected), vars)
+	}
+	for key, value := range expected {
+		if vars[key] != value {
+			t.Errorf("Expected %s=%q, got %q", key, value, vars[key])
+		}
+	}
+}
+
+func TestParseEnvErrors(t *testing.T) {
+	for _, data := range []string{"NOVALUE\n", "=value\n", "A=${UNSET_REQURSE_VAR}\n"} {
+		if _, err := parseEnv([]byte(data), ""); err == nil {
+			t.Errorf("Expected an error for %q", data)
+		}
+	}
+}
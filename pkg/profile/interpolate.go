@@ -0,0 +1,87 @@
+package profile
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// reference matches ${NAME}, ${NAME:-default}, ${env:NAME} and ${file:path}.
+var reference = regexp.MustCompile(`\$\$|\$\{([^}]*)\}`)
+
+// Expand replaces references in s:
+//
+//	${NAME}           the variable NAME, an error when it is not set
+//	${NAME:-default}  the variable NAME, default when it is unset or empty
+//	${env:NAME}       the environment variable NAME, skipping env files
+//	${file:path}      the contents of a file, e.g. a mounted secret, without
+//	                  trailing newlines
+//	$$                a literal $
+//
+// Variables are looked up with lookup first, then in the environment. File
+// paths are relative to dir, ~ is the home directory.
+func Expand(s, dir string, lookup func(string) (string, bool)) (string, error) {
+	var expandErr error
+	expanded := reference.ReplaceAllStringFunc(s, func(match string) string {
+		if match == "$$" {
+			return "$"
+		}
+
+		value, err := resolve(match[2:len(match)-1], dir, lookup)
+		if err != nil && expandErr == nil {
+			expandErr = err
+		}
+		return value
+	})
+	return expanded, expandErr
+}
+
+func resolve(ref, dir string, lookup func(string) (string, bool)) (string, error) {
+	if name, ok := strings.CutPrefix(ref, "env:"); ok {
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s is not set", name)
+		}
+		return value, nil
+	}
+
+	if path, ok := strings.CutPrefix(ref, "file:"); ok {
+		path = expandPath(path, dir)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("reading secret: %w", err)
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	}
+
+	name, fallback, hasFallback := strings.Cut(ref, ":-")
+	value, ok := "", false
+	if lookup != nil {
+		value, ok = lookup(name)
+	}
+	if !ok {
+		value, ok = os.LookupEnv(name)
+	}
+	if hasFallback && value == "" {
+		return fallback, nil
+	}
+	if !ok {
+		return "", fmt.Errorf("variable %s is not set", name)
+	}
+	return value, nil
+}
+
+// expandPath resolves ~ and paths relative to dir.
+func expandPath(path, dir string) string {
+	if rest, ok := strings.CutPrefix(path, "~/"); ok {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, rest)
+		}
+	}
+	if !filepath.IsAbs(path) && dir != "" {
+		return filepath.Join(dir, path)
+	}
+	return path
+}
@@ -0,0 +1,49 @@
+package profile
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestExpand(t *testing.T) {
+	t.Setenv("REQURSE_TEST_ENV", "from-env")
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "token"), []byte("s3cret\n"), 0600); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	vars := map[string]string{"NAME": "from-file", "REQURSE_TEST_ENV": "shadowed", "EMPTY": ""}
+	lookup := func(name string) (string, bool) {
+		value, ok := vars[name]
+		return value, ok
+	}
+
+	tests := map[string]string{
+		"${NAME}":                   "from-file",
+		"${REQURSE_TEST_ENV}":       "shadowed",
+		"${env:REQURSE_TEST_ENV}":   "from-env",
+		"${MISSING:-fallback}":      "fallback",
+		"${EMPTY:-fallback}":        "fallback",
+		"Bearer ${file:token}":      "Bearer s3cret",
+		"$${NAME} costs $5":         "${NAME} costs $5",
+		"https://${NAME}.test:8443": "https://from-file.test:8443",
+	}
+	for input, expected := range tests {
+		got, err := Expand(input, dir, lookup)
+		if err != nil {
+			t.Fatalf("Expected no error for %q, got %v", input, err)
+		}
+		if got != expected {
+			t.Errorf("Expected %q for %q, got %q", expected, input, got)
+		}
+	}
+}
+
+func TestExpandErrors(t *testing.T) {
+	for _, input := range []string{"${MISSING_REQURSE_VAR}", "${env:MISSING_REQURSE_VAR}", "${file:missing}"} {
+		if _, err := Expand(input, t.TempDir(), nil); err == nil {
+			t.Errorf("Expected an error for %q", input)
+		}
+	}
+}
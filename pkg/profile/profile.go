@@ -0,0 +1,212 @@
+// Package profile loads the settings of an environment, such as dev, staging
+// or prod, from YAML or dotenv files.
+package profile
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/defektive/requrse/pkg/request"
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultDir is where profiles are looked up by name.
+const DefaultDir = "profiles"
+
+// envPrefix starts the keys of a dotenv profile that set profile fields,
+// other keys are only variables.
+const envPrefix = "REQURSE_"
+
+// Profile holds the settings of one environment.
+//
+//	host: api.staging.example.com
+//	auth: ${file:~/.secrets/staging-token}
+//	proxy: http://127.0.0.1:8080
+//	tls:
+//	  ca_file: staging-ca.pem
+//	extra:
+//	  tenant: ${TENANT:-acme}
+type Profile struct {
+	Host  string            `yaml:"host"`
+	Auth  string            `yaml:"auth"`
+	Proxy string            `yaml:"proxy"`
+	TLS   request.TLSConfig `yaml:"tls"`
+	Extra map[string]string `yaml:"extra"`
+	// EnvFile is a dotenv file, relative to the profile, defining variables
+	// the values of the profile can reference.
+	EnvFile string `yaml:"env_file"`
+
+	// File is the file the profile was loaded from.
+	File string `yaml:"-"`
+}
+
+// Find returns the file of the profile name in dir, name.yaml, name.yml or
+// name.env. A name that is the path of a file is used as it is.
+func Find(dir, name string) (string, error) {
+	if strings.ContainsRune(name, filepath.Separator) || filepath.Ext(name) != "" {
+		if _, err := os.Stat(name); err == nil {
+			return name, nil
+		}
+	}
+
+	for _, ext := range []string{".yaml", ".yml", ".env"} {
+		file := filepath.Join(dir, name+ext)
+		if _, err := os.Stat(file); err == nil {
+			return file, nil
+		}
+	}
+	return "", fmt.Errorf("no profile %q in %s", name, dir)
+}
+
+// Load loads the profile name from dir.
+func Load(dir, name string) (*Profile, error) {
+	file, err := Find(dir, name)
+	if err != nil {
+		return nil, err
+	}
+	return LoadFile(file)
+}
+
+// LoadFile loads a profile from a YAML file, or from a dotenv file when it
+// has a .env extension.
+func LoadFile(filename string) (*Profile, error) {
+	var p *Profile
+	var err error
+	if filepath.Ext(filename) == ".env" {
+		p, err = loadEnv(filename)
+	} else {
+		p, err = loadYAML(filename)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("profile %s: %w", filename, err)
+	}
+
+	dir := filepath.Dir(filename)
+	for _, path := range []*string{&p.TLS.CAFile, &p.TLS.CertFile, &p.TLS.KeyFile} {
+		if *path != "" {
+			*path = expandPath(*path, dir)
+		}
+	}
+	p.File = filename
+	return p, nil
+}
+
+func loadYAML(filename string) (*Profile, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	if len(doc.Content) == 0 {
+		return nil, errors.New("empty profile")
+	}
+
+	dir := filepath.Dir(filename)
+	var envFile struct {
+		EnvFile string `yaml:"env_file"`
+	}
+	if err := doc.Decode(&envFile); err != nil {
+		return nil, err
+	}
+	vars := map[string]string{}
+	if envFile.EnvFile != "" {
+		if vars, err = ReadEnvFile(expandPath(envFile.EnvFile, dir)); err != nil {
+			return nil, err
+		}
+	}
+
+	lookup := func(name string) (string, bool) {
+		value, ok := vars[name]
+		return value, ok
+	}
+	if err := expandNode(doc.Content[0], dir, lookup); err != nil {
+		return nil, err
+	}
+
+	p := &Profile{}
+	if err := doc.Decode(p); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// expandNode expands the references in every value of node, see Expand.
+func expandNode(node *yaml.Node, dir string, lookup func(string) (string, bool)) error {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		expanded, err := Expand(node.Value, dir, lookup)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", node.Line, err)
+		}
+		node.Value = expanded
+	case yaml.MappingNode:
+		for i := 1; i < len(node.Content); i += 2 {
+			if err := expandNode(node.Content[i], dir, lookup); err != nil {
+				return err
+			}
+		}
+	case yaml.SequenceNode:
+		for _, n := range node.Content {
+			if err := expandNode(n, dir, lookup); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// loadEnv loads a dotenv profile. REQURSE_HOST, REQURSE_AUTH, REQURSE_PROXY,
+// REQURSE_TLS_* and REQURSE_EXTRA_<name> set the fields of the profile.
+func loadEnv(filename string) (*Profile, error) {
+	vars, err := ReadEnvFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Profile{}
+	for key, value := range vars {
+		name, ok := strings.CutPrefix(key, envPrefix)
+		if !ok {
+			continue
+		}
+
+		switch name {
+		case "HOST":
+			p.Host = value
+		case "AUTH":
+			p.Auth = value
+		case "PROXY":
+			p.Proxy = value
+		case "TLS_INSECURE_SKIP_VERIFY":
+			if p.TLS.InsecureSkipVerify, err = strconv.ParseBool(value); err != nil {
+				return nil, fmt.Errorf("%s: %w", key, err)
+			}
+		case "TLS_SERVER_NAME":
+			p.TLS.ServerName = value
+		case "TLS_CA_FILE":
+			p.TLS.CAFile = value
+		case "TLS_CERT_FILE":
+			p.TLS.CertFile = value
+		case "TLS_KEY_FILE":
+			p.TLS.KeyFile = value
+		default:
+			extra, ok := strings.CutPrefix(name, "EXTRA_")
+			if !ok {
+				return nil, fmt.Errorf("unknown profile setting %s", key)
+			}
+			if p.Extra == nil {
+				p.Extra = map[string]string{}
+			}
+			p.Extra[extra] = value
+		}
+	}
+	return p, nil
+}
@@ -0,0 +1,124 @@
+package profile
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+	}
+	return dir
+}
+
+func TestLoadYAML(t *testing.T) {
+	t.Setenv("REQURSE_TEST_TENANT", "")
+	dir := writeFiles(t, map[string]string{
+		"staging.yaml": `host: ${HOST}
+auth: Bearer ${file:token}
+proxy: http://127.0.0.1:8080
+env_file: staging.vars
+tls:
+  ca_file: ca.pem
+  server_name: api.internal
+extra:
+  tenant: ${REQURSE_TEST_TENANT:-acme}
+  price: $$5
+`,
+		"staging.vars": "HOST=api.staging.test\n",
+		"token":        "s3cret\n",
+	})
+
+	p, err := Load(dir, "staging")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if p.Host != "api.staging.test" {
+		t.Errorf("Expected host from env_file, got %q", p.Host)
+	}
+	if p.Auth != "Bearer s3cret" {
+		t.Errorf("Expected auth from secret file, got %q", p.Auth)
+	}
+	if p.Proxy != "http://127.0.0.1:8080" {
+		t.Errorf("Expected proxy, got %q", p.Proxy)
+	}
+	if p.TLS.CAFile != filepath.Join(dir, "ca.pem") {
+		t.Errorf("Expected ca_file relative to the profile, got %q", p.TLS.CAFile)
+	}
+	if p.TLS.ServerName != "api.internal" {
+		t.Errorf("Expected server_name, got %q", p.TLS.ServerName)
+	}
+	if p.Extra["tenant"] != "acme" || p.Extra["price"] != "$5" {
+		t.Errorf("Expected extra tenant=acme price=$5, got %v", p.Extra)
+	}
+	if p.File != filepath.Join(dir, "staging.yaml") {
+		t.Errorf("Expected file %s, got %s", filepath.Join(dir, "staging.yaml"), p.File)
+	}
+}
+
+func TestLoadEnv(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"dev.env": `DOMAIN=dev.test
+REQURSE_HOST=api.${DOMAIN}
+REQURSE_AUTH=dev-token
+REQURSE_TLS_INSECURE_SKIP_VERIFY=true
+REQURSE_TLS_CERT_FILE=client.pem
+REQURSE_EXTRA_tenant=acme
+`,
+	})
+
+	p, err := Load(dir, "dev")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if p.Host != "api.dev.test" {
+		t.Errorf("Expected host api.dev.test, got %q", p.Host)
+	}
+	if p.Auth != "dev-token" {
+		t.Errorf("Expected auth dev-token, got %q", p.Auth)
+	}
+	if !p.TLS.InsecureSkipVerify {
+		t.Errorf("Expected insecure_skip_verify")
+	}
+	if p.TLS.CertFile != filepath.Join(dir, "client.pem") {
+		t.Errorf("Expected cert_file relative to the profile, got %q", p.TLS.CertFile)
+	}
+	if len(p.Extra) != 1 || p.Extra["tenant"] != "acme" {
+		t.Errorf("Expected extra tenant=acme, got %v", p.Extra)
+	}
+}
+
+func TestLoadByPath(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"prod.yml": "host: api.test\n"})
+
+	p, err := Load("profiles", filepath.Join(dir, "prod.yml"))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if p.Host != "api.test" {
+		t.Errorf("Expected host api.test, got %q", p.Host)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"unset.yaml":   "host: ${UNSET_REQURSE_VAR}\n",
+		"unknown.env":  "REQURSE_HOTS=typo\n",
+		"insecure.env": "REQURSE_TLS_INSECURE_SKIP_VERIFY=maybe\n",
+		"empty.yaml":   "",
+	})
+
+	for _, name := range []string{"missing", "unset", "unknown", "insecure", "empty"} {
+		if _, err := Load(dir, name); err == nil {
+			t.Errorf("Expected an error loading %s", name)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package request
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/bits"
+	"slices"
+	"strings"
+)
+
+// DefaultAnomalyThreshold is the score at or above which a response is
+// flagged as anomalous when Baseline.Threshold is not set.
+const DefaultAnomalyThreshold = 0.3
+
+// weights for each feature that can deviate from the baseline. They add up
+// to 1 so the score reads as "how different is this response".
+var anomalyWeights = map[string]float64{
+	"status":  0.3,
+	"length":  0.2,
+	"words":   0.15,
+	"lines":   0.1,
+	"headers": 0.1,
+	"body":    0.15,
+}
+
+// Baseline configures anomaly detection. The first Samples responses are
+// used to learn what a normal response looks like, every response after
+// that is scored against them.
+type Baseline struct {
+	Samples   int     `yaml:"samples"`
+	Threshold float64 `yaml:"threshold"`
+	// SimilarityBits is how many bits the body simhash may differ from the
+	// closest baseline sample before the body counts as different.
+	SimilarityBits int `yaml:"similarity_bits"`
+
+	learned []ResponseFeatures
+}
+
+// ResponseFeatures are the properties of a response that anomaly detection
+// compares.
+type ResponseFeatures struct {
+	Status  int      `json:"status"`
+	Length  int      `json:"length"`
+	Words   int      `json:"words"`
+	Lines   int      `json:"lines"`
+	Headers []string `json:"headers"`
+	SimHash string   `json:"simhash"`
+
+	simHash uint64
+}
+
+// Anomaly describes how a response compares to the baseline.
+type Anomaly struct {
+	// Learning is true while the response is still part of the baseline.
+	Learning  bool             `json:"learning"`
+	Score     float64          `json:"score"`
+	Anomalous bool             `json:"anomalous"`
+	Reasons   []string         `json:"reasons"`
+	Features  ResponseFeatures `json:"features"`
+}
+
+// NewResponseFeatures extracts the features of a response.
+func NewResponseFeatures(sr *SimpleResponse) ResponseFeatures {
+	f := ResponseFeatures{
+		Status: sr.Status,
+		Length: sr.size(),
+		Words:  countWords(sr.RawBody),
+		Lines:  countLines(sr.RawBody),
+	}
+
+	for name := range sr.Headers {
+		f.Headers = append(f.Headers, strings.ToLower(name))
+	}
+	slices.Sort(f.Headers)
+
+	f.simHash = simHash(sr.RawBody)
+	f.SimHash = fmt.Sprintf("%016x", f.simHash)
+
+	return f
+}
+
+// Score learns from or scores sr, depending on how many samples have been
+// seen so far.
+func (b *Baseline) Score(sr *SimpleResponse) *Anomaly {
+	features := NewResponseFeatures(sr)
+	a := &Anomaly{Features: features, Reasons: []string{}}
+
+	if len(b.learned) < b.Samples {
+		b.learned = append(b.learned, features)
+		a.Learning = true
+		return a
+	}
+
+	if b.statusDeviates(features.Status) {
+		a.Reasons = append(a.Reasons, "status")
+	}
+	if b.deviates(features.Length, func(f ResponseFeatures) int { return f.Length }) {
+		a.Reasons = append(a.Reasons, "length")
+	}
+	if b.deviates(features.Words, func(f ResponseFeatures) int { return f.Words }) {
+		a.Reasons = append(a.Reasons, "words")
+	}
+	if b.deviates(features.Lines, func(f ResponseFeatures) int { return f.Lines }) {
+		a.Reasons = append(a.Reasons, "lines")
+	}
+	if b.headersDeviate(features.Headers) {
+		a.Reasons = append(a.Reasons, "headers")
+	}
+	if b.bodyDeviates(features.simHash) {
+		a.Reasons = append(a.Reasons, "body")
+	}
+
+	for _, reason := range a.Reasons {
+		a.Score += anomalyWeights[reason]
+	}
+	// avoid 0.30000000000000004 in output and conditions
+	a.Score = math.Round(a.Score*100) / 100
+
+	threshold := b.Threshold
+	if threshold <= 0 {
+		threshold = DefaultAnomalyThreshold
+	}
+	a.Anomalous = a.Score >= threshold
+
+	return a
+}
+
+func (b *Baseline) statusDeviates(status int) bool {
+	for _, f := range b.learned {
+		if f.Status == status {
+			return false
+		}
+	}
+	return len(b.learned) > 0
+}
+
+// deviates reports whether value is more than three standard deviations
+// from the baseline mean, with a small floor so perfectly stable baselines
+// don't flag a one byte difference.
+func (b *Baseline) deviates(value int, feature func(ResponseFeatures) int) bool {
+	if len(b.learned) == 0 {
+		return false
+	}
+
+	var sum float64
+	for _, f := range b.learned {
+		sum += float64(feature(f))
+	}
+	mean := sum / float64(len(b.learned))
+
+	var variance float64
+	for _, f := range b.learned {
+		d := float64(feature(f)) - mean
+		variance += d * d
+	}
+	stddev := math.Sqrt(variance / float64(len(b.learned)))
+
+	tolerance := math.Max(3*stddev, math.Max(1, mean*0.02))
+	return math.Abs(float64(value)-mean) > tolerance
+}
+
+func (b *Baseline) headersDeviate(headers []string) bool {
+	for _, f := range b.learned {
+		if slices.Equal(f.Headers, headers) {
+			return false
+		}
+	}
+	return len(b.learned) > 0
+}
+
+func (b *Baseline) bodyDeviates(hash uint64) bool {
+	maxBits := b.SimilarityBits
+	if maxBits <= 0 {
+		maxBits = 3
+	}
+
+	for _, f := range b.learned {
+		if bits.OnesCount64(f.simHash^hash) <= maxBits {
+			return false
+		}
+	}
+	return len(b.learned) > 0
+}
+
+// simHash is a 64 bit simhash over the words of body. Similar bodies produce
+// hashes with a small hamming distance.
+func simHash(body string) uint64 {
+	var v [64]int
+	for _, word := range strings.Fields(strings.ToLower(body)) {
+		h := fnv.New64a()
+		h.Write([]byte(word))
+		sum := h.Sum64()
+		for i := 0; i < 64; i++ {
+			if sum&(1<<i) != 0 {
+				v[i]++
+			} else {
+				v[i]--
+			}
+		}
+	}
+
+	var hash uint64
+	for i := 0; i < 64; i++ {
+		if v[i] > 0 {
+			hash |= 1 << i
+		}
+	}
+	return hash
+}
@@ -0,0 +1,46 @@
+package request
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBaselineScore(t *testing.T) {
+	b := &Baseline{Samples: 3}
+	headers := map[string][]string{"Content-Type": {"text/html"}}
+	normal := "<html><body>user not found</body></html>"
+
+	for i := 0; i < 3; i++ {
+		a := b.Score(&SimpleResponse{Status: 200, RawBody: normal, Headers: headers})
+		if !a.Learning {
+			t.Fatalf("Expected response %d to be part of the baseline", i)
+		}
+	}
+
+	a := b.Score(&SimpleResponse{Status: 200, RawBody: normal, Headers: headers})
+	if a.Learning || a.Anomalous || a.Score != 0 {
+		t.Errorf("Expected identical response to score 0, got %+v", a)
+	}
+
+	different := strings.Repeat("welcome back administrator, here is your dashboard\n", 20)
+	a = b.Score(&SimpleResponse{Status: 302, RawBody: different, Headers: map[string][]string{"Location": {"/admin"}}})
+	if !a.Anomalous {
+		t.Errorf("Expected different response to be anomalous, got %+v", a)
+	}
+	if a.Score != 1 {
+		t.Errorf("Expected score 1, got %v (%v)", a.Score, a.Reasons)
+	}
+}
+
+func TestSimHashSimilarity(t *testing.T) {
+	a := simHash("the quick brown fox jumps over the lazy dog")
+	b := simHash("the quick brown fox jumps over the lazy dog")
+	if a != b {
+		t.Errorf("Expected equal bodies to hash the same")
+	}
+
+	c := simHash("completely unrelated content about something else entirely")
+	if a == c {
+		t.Errorf("Expected different bodies to hash differently")
+	}
+}
@@ -0,0 +1,180 @@
+package request
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Values for on_oversize, what to do with a body larger than max_body_size.
+const (
+	OversizeTruncate = "truncate"
+	OversizeAbort    = "abort"
+)
+
+// DefaultStreamPrefix is how much of a streamed body is kept in memory for
+// parsing, conditions and matchers.
+const DefaultStreamPrefix ByteSize = 1 << 20
+
+// checkOnOversize rejects an on_oversize that is not one of the Oversize
+// constants.
+func checkOnOversize(mode string) error {
+	switch strings.ToLower(mode) {
+	case "", OversizeTruncate, OversizeAbort:
+		return nil
+	}
+	return fmt.Errorf("unknown on_oversize %q, expected truncate or abort", mode)
+}
+
+// ErrBodyTooLarge is returned for bodies over max_body_size when on_oversize
+// is abort.
+var ErrBodyTooLarge = errors.New("response body exceeds max_body_size")
+
+// BodySink receives response bodies while they are read, so large bodies
+// never have to be held in memory in full.
+type BodySink interface {
+	// Open is called once the status and headers of a response are known.
+	Open(c *RequestContext, sr *SimpleResponse) (io.WriteCloser, error)
+	// Discard drops a streamed body that is not reported after all.
+	Discard(c *RequestContext, sr *SimpleResponse) error
+}
+
+// ByteSize is a number of bytes. In YAML it is either a plain number or a
+// number with a unit, e.g. "512KB" or "10MiB".
+type ByteSize int64
+
+var byteUnits = map[string]int64{
+	"":    1,
+	"B":   1,
+	"K":   1 << 10,
+	"KB":  1 << 10,
+	"KIB": 1 << 10,
+	"M":   1 << 20,
+	"MB":  1 << 20,
+	"MIB": 1 << 20,
+	"G":   1 << 30,
+	"GB":  1 << 30,
+	"GIB": 1 << 30,
+}
+
+// ParseByteSize parses sizes like "1048576", "512KB" or "10MiB". Units are
+// binary, 1KB is 1024 bytes.
+func ParseByteSize(s string) (ByteSize, error) {
+	s = strings.TrimSpace(s)
+	i := strings.IndexFunc(s, func(r rune) bool { return r < '0' || r > '9' })
+	if i == -1 {
+		i = len(s)
+	}
+
+	n, err := strconv.ParseInt(s[:i], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	unit, ok := byteUnits[strings.ToUpper(strings.TrimSpace(s[i:]))]
+	if !ok {
+		return 0, fmt.Errorf("invalid size unit in %q", s)
+	}
+	return ByteSize(n * unit), nil
+}
+
+func (b *ByteSize) UnmarshalYAML(value *yaml.Node) error {
+	size, err := ParseByteSize(value.Value)
+	if err != nil {
+		return err
+	}
+	*b = size
+	return nil
+}
+
+// readBody reads a response body into sr, enforcing max_body_size and
+// handing the body to the BodySink when there is one. A streamed body only
+// keeps its first StreamPrefix bytes in sr.Body.
+func (tr *TemplateRequest) readBody(c *RequestContext, sr *SimpleResponse, r io.Reader) error {
+	limit := int64(tr.MaxBodySize)
+	if limit > 0 {
+		r = io.LimitReader(r, limit+1)
+	}
+
+	kept := &prefixWriter{max: -1}
+	hash := sha256.New()
+	dst := io.MultiWriter(kept, hash)
+
+	var sink io.WriteCloser
+	if tr.BodySink != nil {
+		var err error
+		if sink, err = tr.BodySink.Open(c, sr); err != nil {
+			return err
+		}
+		sr.Streamed = true
+		kept.max = int64(tr.StreamPrefix)
+		if kept.max <= 0 {
+			kept.max = int64(DefaultStreamPrefix)
+		}
+		dst = io.MultiWriter(sink, kept, hash)
+	}
+
+	src := r
+	if limit > 0 {
+		src = io.LimitReader(r, limit)
+	}
+	n, err := io.Copy(dst, src)
+	if err == nil && limit > 0 && n == limit {
+		// the limit reader lets one more byte through if there is one
+		if extra, _ := io.ReadFull(r, make([]byte, 1)); extra > 0 {
+			if strings.EqualFold(tr.OnOversize, OversizeAbort) {
+				err = fmt.Errorf("%w (%d bytes)", ErrBodyTooLarge, limit)
+			} else {
+				sr.Truncated = true
+			}
+		}
+	}
+
+	if sink != nil {
+		if closeErr := sink.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			tr.BodySink.Discard(c, sr)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	if sink != nil && n > kept.max {
+		sr.Truncated = true
+		tr.logger().Printf("streamed body of %d bytes, parsing, conditions and matchers only see the first %d", n, kept.max)
+	}
+
+	sr.Body = kept.buf.Bytes()
+	sr.Size = n
+	sr.BodyHash = hex.EncodeToString(hash.Sum(nil))
+	return nil
+}
+
+// prefixWriter keeps the first max bytes written to it and drops the rest.
+// A negative max keeps everything.
+type prefixWriter struct {
+	buf bytes.Buffer
+	max int64
+}
+
+func (w *prefixWriter) Write(p []byte) (int, error) {
+	if w.max >= 0 {
+		room := w.max - int64(w.buf.Len())
+		if room < int64(len(p)) {
+			if room > 0 {
+				w.buf.Write(p[:room])
+			}
+			return len(p), nil
+		}
+	}
+	return w.buf.Write(p)
+}
@@ -0,0 +1,164 @@
+package request
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseByteSize(t *testing.T) {
+	tests := map[string]ByteSize{
+		"1024":   1024,
+		"512KB":  512 << 10,
+		"10MiB":  10 << 20,
+		"2 gb":   2 << 30,
+		"100B":   100,
+		" 1k ":   1024,
+		"123456": 123456,
+	}
+
+	for input, expected := range tests {
+		size, err := ParseByteSize(input)
+		if err != nil {
+			t.Fatalf("Expected no error for %q, got %v", input, err)
+		}
+		if size != expected {
+			t.Errorf("Expected %d for %q, got %d", expected, input, size)
+		}
+	}
+
+	for _, input := range []string{"", "MB", "10XB", "-1"} {
+		if _, err := ParseByteSize(input); err == nil {
+			t.Errorf("Expected error for %q", input)
+		}
+	}
+}
+
+func TestByteSizeYAML(t *testing.T) {
+	tr, err := FromBytes([]byte("url: http://example.com\nmax_body_size: 1MB\nstream_prefix: 4096\non_oversize: abort\n"))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if tr.MaxBodySize != 1<<20 || tr.StreamPrefix != 4096 || tr.OnOversize != OversizeAbort {
+		t.Errorf("Expected size settings to be parsed, got %d %d %s", tr.MaxBodySize, tr.StreamPrefix, tr.OnOversize)
+	}
+}
+
+func TestUnknownOnOversize(t *testing.T) {
+	if _, err := FromBytes([]byte("url: http://example.com\nmax_body_size: 1MB\non_oversize: drop\n")); err == nil {
+		t.Error("Expected an error for an unknown on_oversize")
+	}
+
+	tr := &TemplateRequest{URL: "http://localhost", OnOversize: "drop"}
+	if _, _, err := tr.Send(&RequestContext{}); err == nil {
+		t.Error("Expected an error for an on_oversize set after loading")
+	}
+}
+
+type bufferSink struct {
+	buf       bytes.Buffer
+	opened    int
+	discarded int
+}
+
+func (s *bufferSink) Open(c *RequestContext, sr *SimpleResponse) (io.WriteCloser, error) {
+	s.opened++
+	s.buf.Reset()
+	return nopWriteCloser{&s.buf}, nil
+}
+
+func (s *bufferSink) Discard(c *RequestContext, sr *SimpleResponse) error {
+	s.discarded++
+	return nil
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+func TestReadBodyLimits(t *testing.T) {
+	body := strings.Repeat("a", 100)
+
+	tr := &TemplateRequest{MaxBodySize: 10}
+	sr := &SimpleResponse{}
+	if err := tr.readBody(nil, sr, strings.NewReader(body)); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(sr.Body) != 10 || sr.Size != 10 || !sr.Truncated {
+		t.Errorf("Expected body truncated to 10 bytes, got %d (size %d, truncated %v)", len(sr.Body), sr.Size, sr.Truncated)
+	}
+
+	tr = &TemplateRequest{MaxBodySize: 100}
+	sr = &SimpleResponse{}
+	if err := tr.readBody(nil, sr, strings.NewReader(body)); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if sr.Truncated || len(sr.Body) != 100 {
+		t.Errorf("Expected a body of exactly max_body_size to be kept whole, got %d (truncated %v)", len(sr.Body), sr.Truncated)
+	}
+
+	tr = &TemplateRequest{MaxBodySize: 10, OnOversize: OversizeAbort}
+	if err := tr.readBody(nil, &SimpleResponse{}, strings.NewReader(body)); !errors.Is(err, ErrBodyTooLarge) {
+		t.Errorf("Expected ErrBodyTooLarge, got %v", err)
+	}
+}
+
+func TestReadBodyStreams(t *testing.T) {
+	body := strings.Repeat("a", 100)
+	sink := &bufferSink{}
+
+	tr := &TemplateRequest{BodySink: sink, StreamPrefix: 16}
+	sr := &SimpleResponse{}
+	if err := tr.readBody(nil, sr, strings.NewReader(body)); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if sink.buf.String() != body {
+		t.Errorf("Expected the whole body in the sink, got %d bytes", sink.buf.Len())
+	}
+	if len(sr.Body) != 16 || sr.Size != 100 || !sr.Streamed {
+		t.Errorf("Expected a 16 byte prefix of a 100 byte body, got %d of %d (streamed %v)", len(sr.Body), sr.Size, sr.Streamed)
+	}
+	if !sr.Truncated {
+		t.Error("Expected a body cut at the stream prefix to be marked truncated")
+	}
+
+	tr = &TemplateRequest{BodySink: sink, MaxBodySize: 10, OnOversize: OversizeAbort}
+	if err := tr.readBody(nil, &SimpleResponse{}, strings.NewReader(body)); err == nil {
+		t.Error("Expected error for oversized body")
+	}
+	if sink.discarded != 1 {
+		t.Errorf("Expected the aborted body to be discarded, got %d discards", sink.discarded)
+	}
+}
+
+func TestStreamedResponsesFiltered(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"items": [1, 2, 3]}`))
+	}))
+	defer server.Close()
+
+	sink := &bufferSink{}
+	tr := &TemplateRequest{
+		Method:   "GET",
+		URL:      server.URL,
+		BodySink: sink,
+		Filter:   &Matcher{Status: IntRanges{{Min: 200, Max: 200}}},
+	}
+
+	reported := 0
+	tr.Recurse(&RequestContext{}, func(b []byte) { reported++ })
+
+	if sink.opened != 1 || sink.discarded != 1 || reported != 0 {
+		t.Errorf("Expected the filtered body to be discarded, got opened %d, discarded %d, reported %d", sink.opened, sink.discarded, reported)
+	}
+	if tr.LastResponse.BodyObject.(map[string]any)["items"] == nil {
+		t.Errorf("Expected the streamed prefix to be parsed, got %v", tr.LastResponse.BodyObject)
+	}
+}
@@ -0,0 +1,146 @@
+package request
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultCacheDir is where the cache middleware keeps responses.
+const DefaultCacheDir = ".requrse-cache"
+
+// CacheHeader is set to "hit" on responses the cache middleware answered.
+const CacheHeader = "X-Requrse-Cache"
+
+// CacheOptions configure the cache middleware.
+//
+//	middleware:
+//	  - name: cache
+//	    dir: .requrse-cache
+//	    ttl: 1h
+//	    methods: [GET, POST]
+//	    vary: [X-Tenant]
+type CacheOptions struct {
+	// Dir holds one file per cached response, DefaultCacheDir when empty.
+	Dir string `yaml:"dir"`
+	// TTL is how long a response is used, forever when zero.
+	TTL Duration `yaml:"ttl"`
+	// Methods are the methods that are cached, GET and HEAD when empty.
+	Methods []string `yaml:"methods"`
+	// Vary lists request headers that are part of the cache key besides
+	// the method, URL, body and credentials.
+	Vary []string `yaml:"vary"`
+}
+
+// cacheCredentials are always part of the cache key, so a run with other
+// credentials never gets the responses of an earlier one.
+var cacheCredentials = []string{"Authorization", "Proxy-Authorization", "Cookie"}
+
+func newCacheMiddleware(tr *TemplateRequest, options *yaml.Node) (Middleware, error) {
+	var opts CacheOptions
+	if err := decodeOptions(options, &opts); err != nil {
+		return nil, err
+	}
+	return CacheMiddleware(opts), nil
+}
+
+// CacheMiddleware answers requests from responses saved on disk by earlier
+// runs, so a template can be worked on without hitting the server again.
+// Server errors, 5xx statuses, are not cached.
+func CacheMiddleware(opts CacheOptions) Middleware {
+	if opts.Dir == "" {
+		opts.Dir = DefaultCacheDir
+	}
+	if len(opts.Methods) == 0 {
+		opts.Methods = []string{http.MethodGet, http.MethodHead}
+	}
+
+	return func(next http.RoundTripper) http.RoundTripper {
+		return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			if !slices.ContainsFunc(opts.Methods, func(m string) bool { return strings.EqualFold(m, req.Method) }) {
+				return next.RoundTrip(req)
+			}
+
+			key, err := opts.key(req)
+			if err != nil {
+				return nil, err
+			}
+			path := filepath.Join(opts.Dir, key[:2], key)
+
+			if resp := opts.load(path, req); resp != nil {
+				return resp, nil
+			}
+
+			resp, err := next.RoundTrip(req)
+			if err != nil || resp.StatusCode >= 500 {
+				return resp, err
+			}
+
+			dump, err := httputil.DumpResponse(resp, true)
+			if err != nil {
+				resp.Body.Close()
+				return nil, err
+			}
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				resp.Body.Close()
+				return nil, err
+			}
+			if err := writeFileAtomic(path, dump); err != nil {
+				resp.Body.Close()
+				return nil, err
+			}
+			return resp, nil
+		})
+	}
+}
+
+// key hashes what identifies a request to the cache.
+func (opts CacheOptions) key(req *http.Request) (string, error) {
+	hash := sha256.New()
+	io.WriteString(hash, req.Method+" "+req.URL.String()+"\n")
+	for _, name := range slices.Concat(cacheCredentials, opts.Vary) {
+		io.WriteString(hash, http.CanonicalHeaderKey(name)+": "+strings.Join(req.Header.Values(name), ",")+"\n")
+	}
+
+	if req.Body != nil && req.Body != http.NoBody {
+		body, err := io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return "", err
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		hash.Write(body)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// load returns the cached response at path, nil when there is none that is
+// fresh enough.
+func (opts CacheOptions) load(path string, req *http.Request) *http.Response {
+	info, err := os.Stat(path)
+	if err != nil || (opts.TTL > 0 && time.Since(info.ModTime()) > time.Duration(opts.TTL)) {
+		return nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(data)), req)
+	if err != nil {
+		return nil
+	}
+	resp.Header.Set(CacheHeader, "hit")
+	return resp
+}
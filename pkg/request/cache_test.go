@@ -0,0 +1,166 @@
+package request
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCacheMiddleware(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if r.URL.Path == "/error" {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+		w.Header().Set("X-Call", fmt.Sprint(calls))
+		body, _ := io.ReadAll(r.Body)
+		fmt.Fprintf(w, "%s %s %s", r.Method, r.URL.Path, body)
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	client := &http.Client{Transport: CacheMiddleware(CacheOptions{Dir: dir, Methods: []string{"GET", "POST"}})(http.DefaultTransport)}
+
+	do := func(method, path, body string) (string, *http.Response) {
+		req, _ := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		return string(b), resp
+	}
+
+	body, resp := do("GET", "/a", "")
+	if body != "GET /a " || resp.Header.Get(CacheHeader) != "" {
+		t.Errorf("Expected a response from the server, got %q %v", body, resp.Header)
+	}
+
+	body, resp = do("GET", "/a", "")
+	if body != "GET /a " || resp.Header.Get(CacheHeader) != "hit" || resp.Header.Get("X-Call") != "1" {
+		t.Errorf("Expected the cached response, got %q %v", body, resp.Header)
+	}
+
+	do("POST", "/a", "one")
+	body, _ = do("POST", "/a", "two")
+	if body != "POST /a two" {
+		t.Errorf("Expected the body to be part of the key, got %q", body)
+	}
+	body, resp = do("POST", "/a", "one")
+	if body != "POST /a one" || resp.Header.Get(CacheHeader) != "hit" {
+		t.Errorf("Expected the cached POST response, got %q", body)
+	}
+
+	do("GET", "/error", "")
+	do("GET", "/error", "")
+	if calls != 5 {
+		t.Errorf("Expected 5 calls to the server with errors not cached, got %d", calls)
+	}
+}
+
+func TestCacheMiddlewareCredentials(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.Header.Get("Authorization"))
+	}))
+	defer server.Close()
+
+	client := &http.Client{Transport: CacheMiddleware(CacheOptions{Dir: t.TempDir()})(http.DefaultTransport)}
+	get := func(auth string) string {
+		req, _ := http.NewRequest("GET", server.URL, nil)
+		req.Header.Set("Authorization", auth)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		return string(b)
+	}
+
+	get("Token a")
+	if body := get("Token b"); body != "Token b" {
+		t.Errorf("Expected other credentials to miss the cache, got %q", body)
+	}
+}
+
+func TestCacheMiddlewareTTL(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	client := &http.Client{Transport: CacheMiddleware(CacheOptions{Dir: dir, TTL: Duration(time.Hour)})(http.DefaultTransport)}
+
+	get := func() {
+		resp, err := client.Get(server.URL)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		resp.Body.Close()
+	}
+
+	get()
+	get()
+	if calls != 1 {
+		t.Fatalf("Expected the second request from the cache, got %d calls", calls)
+	}
+
+	old := time.Now().Add(-2 * time.Hour)
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			os.Chtimes(path, old, old)
+		}
+		return nil
+	})
+
+	get()
+	if calls != 2 {
+		t.Errorf("Expected a stale response to be fetched again, got %d calls", calls)
+	}
+
+	req, _ := http.NewRequest("DELETE", server.URL, nil)
+	client.Do(req)
+	client.Do(req)
+	if calls != 4 {
+		t.Errorf("Expected DELETE not to be cached, got %d calls", calls)
+	}
+}
+
+func TestDumpMiddleware(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Reply", "yes")
+		w.Write([]byte("pong"))
+	}))
+	defer server.Close()
+
+	var dump bytes.Buffer
+	client := &http.Client{Transport: DumpMiddleware(&dump, true)(http.DefaultTransport)}
+
+	resp, err := client.Post(server.URL+"/ping", "text/plain", strings.NewReader("ping"))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if string(body) != "pong" {
+		t.Errorf("Expected the body to still be readable, got %q", body)
+	}
+	for _, want := range []string{"POST /ping HTTP/1.1", "\r\n\r\nping", "X-Reply: yes", "\r\n\r\npong"} {
+		if !strings.Contains(dump.String(), want) {
+			t.Errorf("Expected the dump to contain %q, got %s", want, dump.String())
+		}
+	}
+}
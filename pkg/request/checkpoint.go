@@ -0,0 +1,242 @@
+package request
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+// DefaultCheckpointEvery is how many iterations pass between checkpoint
+// writes when CheckpointEvery is not set.
+const DefaultCheckpointEvery = 100
+
+// Checkpoint is the state persisted to disk so an interrupted Recurse run can
+// pick up where it stopped.
+type Checkpoint struct {
+	// Iteration is the next iteration to run.
+	Iteration int  `json:"iteration"`
+	Done      bool `json:"done"`
+	// Extra holds the values of the template's extract section.
+	Extra         map[string]interface{} `json:"extra"`
+	ListPositions []int                  `json:"list_positions,omitempty"`
+	LastResponse  SimpleResponse         `json:"last_response"`
+	Cookies       []SavedCookies         `json:"cookies,omitempty"`
+	SavedAt       time.Time              `json:"saved_at"`
+}
+
+// SavedCookies are the cookies a server set for a URL.
+type SavedCookies struct {
+	URL     string         `json:"url"`
+	Cookies []*http.Cookie `json:"cookies"`
+}
+
+// LoadCheckpoint reads a checkpoint written by a previous run.
+func LoadCheckpoint(filename string) (*Checkpoint, error) {
+	f, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	cp := &Checkpoint{}
+	if err := json.Unmarshal(f, cp); err != nil {
+		return nil, fmt.Errorf("parsing checkpoint %s: %w", filename, err)
+	}
+
+	return cp, nil
+}
+
+// Save writes the checkpoint atomically so a crash mid-write never leaves a
+// truncated file behind.
+func (cp *Checkpoint) Save(filename string) error {
+	cp.SavedAt = time.Now()
+	cpBytes, err := json.MarshalIndent(cp, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(filename, cpBytes)
+}
+
+// writeFileAtomic writes data to a temporary file next to filename and
+// renames it into place.
+func writeFileAtomic(filename string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(filename), filepath.Base(filename)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), filename)
+}
+
+// Resume restores the checkpoint into c and tr so the next Recurse call
+// continues with the iteration after the last one checkpointed.
+func (tr *TemplateRequest) Resume(c *RequestContext, cp *Checkpoint) error {
+	for i, pos := range cp.ListPositions {
+		if i >= len(tr.Lists) {
+			return fmt.Errorf("checkpoint has %d lists, template has %d", len(cp.ListPositions), len(tr.Lists))
+		}
+		if pos > len(tr.Lists[i]) {
+			return fmt.Errorf("list %d has %d entries, checkpoint is at %d", i, len(tr.Lists[i]), pos)
+		}
+	}
+
+	// extra data given again for the resumed run wins over the checkpoint
+	for name, value := range cp.Extra {
+		if _, ok := c.Extra[name]; ok {
+			continue
+		}
+		if c.Extra == nil {
+			c.Extra = map[string]interface{}{}
+		}
+		c.Extra[name] = value
+	}
+	tr.LastResponse = cp.LastResponse
+	tr.resumeFrom = cp.Iteration
+	tr.resumeDone = cp.Done
+
+	if len(cp.Cookies) > 0 {
+		tr.CookieJar = true
+		jar := tr.cookieJar()
+		for _, saved := range cp.Cookies {
+			u, err := url.Parse(saved.URL)
+			if err != nil {
+				return err
+			}
+			jar.SetCookies(u, saved.Cookies)
+		}
+	}
+
+	return nil
+}
+
+func (tr *TemplateRequest) checkpoint(c *RequestContext, next int, done bool) *Checkpoint {
+	cp := &Checkpoint{
+		Iteration:    next,
+		Done:         done,
+		LastResponse: tr.LastResponse,
+	}
+	// only extracted values are saved, the rest is passed again on resume
+	// and can hold secrets resolved by a profile
+	for name := range tr.Extract {
+		if value, ok := c.Extra[name]; ok {
+			if cp.Extra == nil {
+				cp.Extra = map[string]interface{}{}
+			}
+			cp.Extra[name] = value
+		}
+	}
+	// the request echo can hold credentials, the next request is
+	// rendered from the response
+	cp.LastResponse.Request = SimpleRequest{}
+
+	for range tr.Lists {
+		cp.ListPositions = append(cp.ListPositions, next)
+	}
+
+	if tr.jar != nil {
+		cp.Cookies = tr.jar.saved()
+	}
+
+	return cp
+}
+
+func (tr *TemplateRequest) saveCheckpoint(c *RequestContext, next int, done bool) {
+	if tr.CheckpointFile == "" {
+		return
+	}
+
+	every := tr.CheckpointEvery
+	if every <= 0 {
+		every = DefaultCheckpointEvery
+	}
+
+	if !done && next%every != 0 {
+		return
+	}
+
+	tr.writeCheckpoint(c, next, done)
+}
+
+// writeCheckpoint saves a checkpoint regardless of CheckpointEvery.
+func (tr *TemplateRequest) writeCheckpoint(c *RequestContext, next int, done bool) {
+	if tr.CheckpointFile == "" {
+		return
+	}
+
+	if err := tr.checkpoint(c, next, done).Save(tr.CheckpointFile); err != nil {
+		tr.logger().Println("error saving checkpoint", err)
+	}
+}
+
+func (tr *TemplateRequest) cookieJar() *recordingJar {
+	if tr.jar == nil {
+		jar, _ := cookiejar.New(nil)
+		tr.jar = &recordingJar{jar: jar, cookies: map[string]map[string]*http.Cookie{}}
+	}
+	return tr.jar
+}
+
+// recordingJar is a cookiejar.Jar that remembers what was set so it can be
+// written to a checkpoint; cookiejar.Jar itself can't be enumerated.
+type recordingJar struct {
+	mu      sync.Mutex
+	jar     *cookiejar.Jar
+	cookies map[string]map[string]*http.Cookie
+}
+
+func (j *recordingJar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	j.jar.SetCookies(u, cookies)
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	key := (&url.URL{Scheme: u.Scheme, Host: u.Host, Path: "/"}).String()
+	if j.cookies[key] == nil {
+		j.cookies[key] = map[string]*http.Cookie{}
+	}
+	for _, cookie := range cookies {
+		saved := *cookie
+		if saved.MaxAge > 0 {
+			// MaxAge is relative, pin it so a later resume doesn't extend it
+			saved.Expires = time.Now().Add(time.Duration(saved.MaxAge) * time.Second)
+			saved.MaxAge = 0
+		}
+		j.cookies[key][saved.Name+";"+saved.Path] = &saved
+	}
+}
+
+func (j *recordingJar) Cookies(u *url.URL) []*http.Cookie {
+	return j.jar.Cookies(u)
+}
+
+func (j *recordingJar) saved() []SavedCookies {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	saved := []SavedCookies{}
+	for _, u := range slices.Sorted(maps.Keys(j.cookies)) {
+		sc := SavedCookies{URL: u}
+		for _, key := range slices.Sorted(maps.Keys(j.cookies[u])) {
+			sc.Cookies = append(sc.Cookies, j.cookies[u][key])
+		}
+		saved = append(saved, sc)
+	}
+	return saved
+}
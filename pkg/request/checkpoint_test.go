@@ -0,0 +1,119 @@
+package request
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckpointSaveLoad(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "checkpoint.json")
+	cp := &Checkpoint{
+		Iteration:     31,
+		Extra:         map[string]interface{}{"user": "alice"},
+		ListPositions: []int{31},
+		LastResponse:  SimpleResponse{Status: 200},
+	}
+
+	if err := cp.Save(filename); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	loaded, err := LoadCheckpoint(filename)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if loaded.Iteration != 31 || loaded.Extra["user"] != "alice" || loaded.LastResponse.Status != 200 {
+		t.Errorf("Expected checkpoint to round trip, got %+v", loaded)
+	}
+}
+
+func TestRecurseResume(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "abc"})
+		fmt.Fprintf(w, `{"page": %s}`, r.URL.Query().Get("page"))
+	}))
+	defer server.Close()
+
+	filename := filepath.Join(t.TempDir(), "checkpoint.json")
+	tr := &TemplateRequest{
+		Method:          "GET",
+		URL:             server.URL + "/?page={{.Page}}",
+		StopWhen:        []string{`select(.body_object.page == 3) | .`},
+		CookieJar:       true,
+		CheckpointFile:  filename,
+		CheckpointEvery: 1,
+	}
+
+	pages := 0
+	tr.Recurse(&RequestContext{}, func(body []byte) { pages++ })
+	if pages != 3 {
+		t.Fatalf("Expected 3 pages, got %d", pages)
+	}
+
+	cp, err := LoadCheckpoint(filename)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !cp.Done || cp.Iteration != 3 {
+		t.Errorf("Expected done checkpoint at iteration 3, got %+v", cp)
+	}
+	if len(cp.Cookies) != 1 || cp.Cookies[0].Cookies[0].Value != "abc" {
+		t.Errorf("Expected session cookie in checkpoint, got %+v", cp.Cookies)
+	}
+
+	// pretend the run died after the first page
+	cp.Iteration = 1
+	cp.Done = false
+
+	resumed := &TemplateRequest{
+		Method:   "GET",
+		URL:      server.URL + "/?page={{.Page}}",
+		StopWhen: []string{`select(.body_object.page == 3) | .`},
+	}
+	c := &RequestContext{}
+	if err := resumed.Resume(c, cp); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	firstPage := 0
+	resumed.Recurse(c, func(body []byte) {
+		if firstPage == 0 {
+			firstPage = c.Page
+		}
+	})
+	if firstPage != 2 {
+		t.Errorf("Expected resume to start at page 2, got %d", firstPage)
+	}
+}
+
+func TestResumeListMismatch(t *testing.T) {
+	tr := &TemplateRequest{Lists: [][]string{{"a", "b"}}}
+	cp := &Checkpoint{Iteration: 5, ListPositions: []int{5}}
+
+	if err := tr.Resume(&RequestContext{}, cp); err == nil {
+		t.Error("Expected error for list shorter than checkpoint position")
+	}
+}
+
+func TestCheckpointExtra(t *testing.T) {
+	tr := &TemplateRequest{Extract: map[string]string{"cursor": ".body_object.next"}}
+	c := &RequestContext{Extra: map[string]interface{}{"cursor": "abc", "token": "s3cret", "max": "5"}}
+
+	cp := tr.checkpoint(c, 3, false)
+	if len(cp.Extra) != 1 || cp.Extra["cursor"] != "abc" {
+		t.Errorf("Expected only extracted values in the checkpoint, got %v", cp.Extra)
+	}
+
+	cp.Extra["max"] = "5"
+	resumed := &RequestContext{Extra: map[string]interface{}{"max": "2"}}
+	if err := tr.Resume(resumed, cp); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if resumed.Extra["max"] != "2" || resumed.Extra["cursor"] != "abc" {
+		t.Errorf("Expected extra data passed on resume to win, got %v", resumed.Extra)
+	}
+}
@@ -0,0 +1,100 @@
+package request
+
+import (
+	"encoding/json"
+
+	"github.com/itchyny/gojq"
+)
+
+// CollectedItem is one result of the template's collect expression.
+type CollectedItem struct {
+	Value any
+	// Key is the JSON encoded result of collect_key, used to drop
+	// duplicates. It is empty without collect_key or when the key is null.
+	Key string
+}
+
+// compileCollect compiles collect and collect_key. Compile calls it, it
+// runs again when they are set after the template was compiled.
+func (tr *TemplateRequest) compileCollect() error {
+	tr.collectCode, tr.collectKeyCode = nil, nil
+	if tr.Collect == "" {
+		return nil
+	}
+
+	var err error
+	if tr.collectCode, err = compileJQ(tr.Collect); err != nil {
+		return err
+	}
+	if tr.CollectKey != "" {
+		if tr.collectKeyCode, err = compileJQ(tr.CollectKey); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// collect runs the collect expression against the parsed body and stores
+// the results in sr.Collected.
+func (tr *TemplateRequest) collect(c *RequestContext, sr *SimpleResponse) {
+	if tr.Collect == "" {
+		return
+	}
+	if tr.collectCode == nil {
+		if err := tr.compileCollect(); err != nil {
+			tr.logger().Println(err)
+			return
+		}
+	}
+
+	vars := sr.jqVariables(c)
+	iter := tr.collectCode.Run(sr.bodyValue(), vars...)
+	for {
+		v, ok := iter.Next()
+		if !ok {
+			return
+		}
+		if err, ok := v.(error); ok {
+			if err, ok := err.(*gojq.HaltError); ok && err.Value() == nil {
+				return
+			}
+			tr.logger().Println("error collecting items:", err)
+			return
+		}
+
+		sr.Collected = append(sr.Collected, CollectedItem{Value: v, Key: tr.collectKey(v, vars)})
+	}
+}
+
+// CollectItem wraps v, an item collected by an earlier run, with its
+// collect_key, so a resumed run skips items it already has.
+func (tr *TemplateRequest) CollectItem(v any) CollectedItem {
+	if tr.CollectKey != "" && tr.collectKeyCode == nil {
+		if err := tr.compileCollect(); err != nil {
+			tr.logger().Println(err)
+			return CollectedItem{Value: v}
+		}
+	}
+	return CollectedItem{Value: v, Key: tr.collectKey(v, jqVariables(nil))}
+}
+
+func (tr *TemplateRequest) collectKey(item any, vars []any) string {
+	if tr.collectKeyCode == nil {
+		return ""
+	}
+
+	v, ok := tr.collectKeyCode.Run(item, vars...).Next()
+	if !ok || v == nil {
+		return ""
+	}
+	if err, ok := v.(error); ok {
+		tr.logger().Println("error computing collect key:", err)
+		return ""
+	}
+
+	key, err := json.Marshal(v)
+	if err != nil {
+		return ""
+	}
+	return string(key)
+}
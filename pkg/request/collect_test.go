@@ -0,0 +1,73 @@
+package request
+
+import (
+	"testing"
+)
+
+func TestCollect(t *testing.T) {
+	RegisterTransport("echo-collect", func(tr *TemplateRequest) (Transport, error) { return &echoTransport{}, nil })
+
+	tr, err := FromBytes([]byte(`
+url: echo-collect://local
+body: '[{"id": {{.Page}}, "page": {{.Page}}}, {"id": 1, "page": {{.Page}}}]'
+collect: '.[] | select(.page <= $page)'
+collect_key: '.id'
+max_iterations: 2
+`))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	collected := [][]CollectedItem{}
+	tr.Recurse(&RequestContext{}, func(body []byte) {
+		collected = append(collected, tr.LastResponse.Collected)
+	})
+
+	if len(collected) != 2 || len(collected[1]) != 2 {
+		t.Fatalf("Expected two items from each of two pages, got %v", collected)
+	}
+	item := collected[1][0]
+	if item.Key != "2" || item.Value.(map[string]any)["page"] != 2.0 {
+		t.Errorf("Expected item with key 2 from page 2, got %+v", item)
+	}
+	if collected[0][1].Key != collected[1][1].Key {
+		t.Errorf("Expected the repeated item to have the same key, got %q and %q", collected[0][1].Key, collected[1][1].Key)
+	}
+}
+
+func TestCollectInvalidExpression(t *testing.T) {
+	if _, err := FromBytes([]byte("collect: '.items[' \n")); err == nil {
+		t.Error("Expected error for invalid collect expression")
+	}
+}
+
+func TestCollectSkipsRepeatedBody(t *testing.T) {
+	RegisterTransport("echo-collect-repeat", func(tr *TemplateRequest) (Transport, error) { return &echoTransport{}, nil })
+
+	tr, err := FromBytes([]byte(`
+url: echo-collect-repeat://local
+body: '[{"page": {{if lt .Page 2}}{{.Page}}{{else}}2{{end}}}]'
+collect: '.[]'
+stop_on_repeat: true
+max_iterations: 10
+`))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	items := []CollectedItem{}
+	tr.Recurse(&RequestContext{}, func(body []byte) {
+		items = append(items, tr.LastResponse.Collected...)
+	})
+
+	if len(items) != 2 {
+		t.Errorf("Expected the repeated last page to be collected once, got %v", items)
+	}
+}
+
+func TestCollectItem(t *testing.T) {
+	tr := &TemplateRequest{Collect: ".items[]", CollectKey: ".id"}
+	if item := tr.CollectItem(map[string]any{"id": 7.0}); item.Key != "7" {
+		t.Errorf("Expected key 7, got %q", item.Key)
+	}
+}
@@ -0,0 +1,224 @@
+package request
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/itchyny/gojq"
+	"gopkg.in/yaml.v3"
+)
+
+// Conditions holds the any, all and none lists of a condition field written
+// as a mapping instead of a plain list:
+//
+//	continue_while:
+//	  all:
+//	    - '.body_object.has_more'
+//	  none:
+//	    - '.status >= 500'
+//
+// Every non-empty list has to hold for the conditions to hold, together with
+// the plain list of the field if there is one.
+type Conditions struct {
+	Any  []string `yaml:"any"`
+	All  []string `yaml:"all"`
+	None []string `yaml:"none"`
+}
+
+func (cs *Conditions) UnmarshalYAML(value *yaml.Node) error {
+	type plain Conditions
+	if err := value.Decode((*plain)(cs)); err != nil {
+		return err
+	}
+
+	for i := 0; i < len(value.Content); i += 2 {
+		node := value.Content[i]
+		switch node.Value {
+		case "any", "all", "none":
+		default:
+			return fmt.Errorf("line %d: unknown condition combinator %q, expected any, all or none", node.Line, node.Value)
+		}
+	}
+	return nil
+}
+
+// IsEmpty reports whether there are no expressions at all.
+func (cs *Conditions) IsEmpty() bool {
+	return len(cs.Any) == 0 && len(cs.All) == 0 && len(cs.None) == 0
+}
+
+// Combinators holds the mapping form of stop_when, continue_while and
+// stop_unless.
+type Combinators struct {
+	StopWhen      Conditions
+	ContinueWhile Conditions
+	StopUnless    Conditions
+}
+
+// field returns the conditions of the template key name, nil for keys that
+// are not condition fields.
+func (c *Combinators) field(name string) *Conditions {
+	switch name {
+	case "stop_when":
+		return &c.StopWhen
+	case "continue_while":
+		return &c.ContinueWhile
+	case "stop_unless":
+		return &c.StopUnless
+	}
+	return nil
+}
+
+// UnmarshalYAML decodes stop_when, continue_while and stop_unless written as
+// a mapping into Combinators and a single expression into a list of one, the
+// rest of the template decodes as usual.
+func (tr *TemplateRequest) UnmarshalYAML(value *yaml.Node) error {
+	type plain TemplateRequest
+	if value.Kind != yaml.MappingNode {
+		return value.Decode((*plain)(tr))
+	}
+
+	node := *value
+	node.Content = make([]*yaml.Node, 0, len(value.Content))
+	for i := 0; i+1 < len(value.Content); i += 2 {
+		key, val := value.Content[i], value.Content[i+1]
+		if cs := tr.Combinators.field(key.Value); cs != nil {
+			switch {
+			case val.Kind == yaml.MappingNode:
+				if err := val.Decode(cs); err != nil {
+					return err
+				}
+				continue
+			case val.Kind == yaml.ScalarNode && val.Tag != "!!null":
+				val = sequenceOf(val)
+			}
+		}
+		node.Content = append(node.Content, key, val)
+	}
+	return node.Decode((*plain)(tr))
+}
+
+// compiledConditions are the plain list of a condition field and its
+// combinators, compiled.
+type compiledConditions struct {
+	list, any, all, none []*gojq.Code
+}
+
+func compileConditions(list []string, cs Conditions) (compiledConditions, error) {
+	var cc compiledConditions
+	var err error
+	for _, group := range []struct {
+		exprs []string
+		codes *[]*gojq.Code
+	}{
+		{list, &cc.list},
+		{cs.Any, &cc.any},
+		{cs.All, &cc.all},
+		{cs.None, &cc.none},
+	} {
+		if *group.codes, err = compileJQAll(group.exprs); err != nil {
+			return cc, err
+		}
+	}
+	return cc, nil
+}
+
+func (cc *compiledConditions) isEmpty() bool {
+	return len(cc.list) == 0 && len(cc.any) == 0 && len(cc.all) == 0 && len(cc.none) == 0
+}
+
+// eval reports whether the conditions hold for in. listMode is how the plain
+// list is combined and hit decides whether a jq output counts.
+func (cc *compiledConditions) eval(in jqInput, listMode string, hit func(any) bool) bool {
+	if len(cc.list) > 0 && !combine(listMode, cc.list, in, hit) {
+		return false
+	}
+	if len(cc.any) > 0 && !combine("any", cc.any, in, hit) {
+		return false
+	}
+	if len(cc.all) > 0 && !combine("all", cc.all, in, hit) {
+		return false
+	}
+	if len(cc.none) > 0 && !combine("none", cc.none, in, hit) {
+		return false
+	}
+	return true
+}
+
+func combine(mode string, codes []*gojq.Code, in jqInput, hit func(any) bool) bool {
+	switch mode {
+	case "all":
+		for _, code := range codes {
+			if !runCondition(code, in, hit) {
+				return false
+			}
+		}
+		return true
+	case "none":
+		for _, code := range codes {
+			if runCondition(code, in, hit) {
+				return false
+			}
+		}
+		return true
+	default:
+		for _, code := range codes {
+			if runCondition(code, in, hit) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+func runCondition(code *gojq.Code, in jqInput, hit func(any) bool) bool {
+	iter := code.Run(in.value, in.vars...)
+	for {
+		v, ok := iter.Next()
+		if !ok {
+			return false
+		}
+		if err, ok := v.(error); ok {
+			if err, ok := err.(*gojq.HaltError); ok && err.Value() == nil {
+				return false
+			}
+		}
+
+		if hit(v) {
+			return true
+		}
+	}
+}
+
+// nonNull is how stop_when has always worked: any output other than null,
+// which is what the select(...) | . idiom relies on.
+func nonNull(v any) bool {
+	return v != nil
+}
+
+// truthy follows jq: everything but null and false. Errors never count.
+func truthy(v any) bool {
+	if err, ok := v.(error); ok {
+		log.Println("error evaluating condition", err)
+		return false
+	}
+	return v != nil && v != false
+}
+
+func (tr *TemplateRequest) hasConditions() bool {
+	return !tr.stopWhen.isEmpty() || !tr.continueWhile.isEmpty() || !tr.stopUnless.isEmpty()
+}
+
+// shouldStop evaluates stop_when, continue_while and stop_unless against in.
+func (tr *TemplateRequest) shouldStop(in jqInput) bool {
+	if !tr.stopWhen.isEmpty() && tr.stopWhen.eval(in, "any", nonNull) {
+		return true
+	}
+	if !tr.continueWhile.isEmpty() && !tr.continueWhile.eval(in, "all", truthy) {
+		return true
+	}
+	if !tr.stopUnless.isEmpty() && !tr.stopUnless.eval(in, "any", truthy) {
+		return true
+	}
+	return false
+}
@@ -0,0 +1,101 @@
+package request
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func TestConditionsYAML(t *testing.T) {
+	tr, err := FromBytes([]byte(`
+stop_when:
+  - 'select(.status == 500) | .'
+continue_while:
+  all:
+    - '.body_object.has_more'
+  none:
+    - '.status >= 400'
+stop_unless: '.status == 200'
+max_iterations: 10
+`))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if len(tr.StopWhen) != 1 || len(tr.Combinators.ContinueWhile.All) != 1 || len(tr.Combinators.ContinueWhile.None) != 1 {
+		t.Errorf("Expected conditions to be parsed, got %v %+v", tr.StopWhen, tr.Combinators.ContinueWhile)
+	}
+	if len(tr.StopUnless) != 1 || tr.MaxIterations != 10 {
+		t.Errorf("Expected stop_unless and max_iterations, got %+v %d", tr.StopUnless, tr.MaxIterations)
+	}
+
+	if _, err := FromBytes([]byte("stop_when:\n  most: ['.']\n")); err == nil {
+		t.Error("Expected error for unknown combinator")
+	}
+}
+
+func TestConditionsEval(t *testing.T) {
+	r := map[string]any{"status": 200, "body_object": map[string]any{"has_more": false}}
+
+	tests := []struct {
+		name       string
+		list       []string
+		conditions Conditions
+		expected   bool
+	}{
+		{"any", nil, Conditions{Any: []string{".status == 404", ".status == 200"}}, true},
+		{"all", nil, Conditions{All: []string{".status == 404", ".status == 200"}}, false},
+		{"none", nil, Conditions{None: []string{".status == 404"}}, true},
+		{"false is not truthy", []string{".body_object.has_more"}, Conditions{}, false},
+		{"groups are combined", nil, Conditions{Any: []string{".status == 200"}, None: []string{".status == 200"}}, false},
+		{"list and groups are combined", []string{".status == 200"}, Conditions{None: []string{".status == 200"}}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cc, err := compileConditions(tt.list, tt.conditions)
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if got := cc.eval(jqInput{value: r, vars: jqVariables(nil)}, "any", truthy); got != tt.expected {
+				t.Errorf("Expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestRecurseContinueWhile(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		fmt.Fprintf(w, `{"page": %d, "has_more": %v}`, page, page < 4)
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name     string
+		tr       *TemplateRequest
+		expected int
+	}{
+		{"continue_while", &TemplateRequest{ContinueWhile: []string{".body_object.has_more"}}, 4},
+		{"stop_unless", &TemplateRequest{StopUnless: []string{".body_object.page < 2"}}, 2},
+		{"max_iterations", &TemplateRequest{MaxIterations: 3}, 3},
+		{"max_iterations with conditions", &TemplateRequest{MaxIterations: 2, ContinueWhile: []string{".body_object.has_more"}}, 2},
+		{"combinators", &TemplateRequest{Combinators: Combinators{ContinueWhile: Conditions{None: []string{".body_object.page >= 3"}}}}, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := tt.tr
+			tr.Method = "GET"
+			tr.URL = server.URL + "/?page={{.Page}}"
+
+			pages := 0
+			tr.Recurse(&RequestContext{}, func(body []byte) { pages++ })
+			if pages != tt.expected {
+				t.Errorf("Expected %d pages, got %d", tt.expected, pages)
+			}
+		})
+	}
+}
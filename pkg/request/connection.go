@@ -0,0 +1,185 @@
+package request
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// HTTP versions for Connection.HTTPVersion.
+const (
+	// HTTPVersionAuto negotiates HTTP/2 over TLS and uses HTTP/1.1 otherwise.
+	HTTPVersionAuto = "auto"
+	// HTTPVersion1 forces HTTP/1.1, even for servers offering HTTP/2.
+	HTTPVersion1 = "1.1"
+	// HTTPVersion2 only speaks HTTP/2 over TLS.
+	HTTPVersion2 = "2"
+	// HTTPVersionH2C speaks HTTP/2 over plain TCP without an upgrade, for
+	// local targets that support it (h2c prior knowledge).
+	HTTPVersionH2C = "h2c"
+)
+
+// Connection configures the http.Client every request of a template shares.
+// Zero values keep the net/http defaults.
+//
+//	connection:
+//	  http_version: h2c
+//	  max_idle_conns_per_host: 10
+//	  keep_alive: 15s
+type Connection struct {
+	HTTPVersion         string   `yaml:"http_version"`
+	MaxIdleConns        int      `yaml:"max_idle_conns"`
+	MaxIdleConnsPerHost int      `yaml:"max_idle_conns_per_host"`
+	MaxConnsPerHost     int      `yaml:"max_conns_per_host"`
+	IdleConnTimeout     Duration `yaml:"idle_conn_timeout"`
+	// KeepAlive is the interval between TCP keep-alive probes.
+	KeepAlive Duration `yaml:"keep_alive"`
+	// DisableKeepAlives opens a new connection for every request.
+	DisableKeepAlives bool `yaml:"disable_keep_alives"`
+}
+
+// protocols maps HTTPVersion to the protocols the transport may use.
+func (conn Connection) protocols() (*http.Protocols, error) {
+	protocols := &http.Protocols{}
+	switch strings.ToLower(conn.HTTPVersion) {
+	case "", HTTPVersionAuto:
+		protocols.SetHTTP1(true)
+		protocols.SetHTTP2(true)
+	case HTTPVersion1, "1", "http/1.1":
+		protocols.SetHTTP1(true)
+	case HTTPVersion2, "2.0", "h2":
+		protocols.SetHTTP2(true)
+	case HTTPVersionH2C:
+		protocols.SetUnencryptedHTTP2(true)
+	default:
+		return nil, fmt.Errorf("unknown http_version %q", conn.HTTPVersion)
+	}
+	return protocols, nil
+}
+
+// httpClient returns the client the template sends HTTP requests with. It is
+// built on first use and kept, so connections are reused between iterations.
+// A Runner's client and middleware replace or wrap it for the Runner's runs.
+func (tr *TemplateRequest) httpClient() (*http.Client, error) {
+	if tr.runner != nil && (tr.runner.client != nil || len(tr.runner.middleware) > 0) {
+		return tr.runnerClient()
+	}
+	return tr.templateClient()
+}
+
+// templateClient is the client built from the template's settings.
+func (tr *TemplateRequest) templateClient() (*http.Client, error) {
+	if tr.client != nil {
+		return tr.client, nil
+	}
+
+	transport, err := tr.httpRoundTripper()
+	if err != nil {
+		return nil, err
+	}
+	rt, err := tr.wrapTransport(transport)
+	if err != nil {
+		return nil, err
+	}
+
+	tr.baseTransport = transport
+	tr.client = &http.Client{
+		Transport:     rt,
+		CheckRedirect: tr.checkRedirect,
+	}
+	if tr.CookieJar {
+		tr.client.Jar = tr.cookieJar()
+	}
+	return tr.client, nil
+}
+
+// runnerClient is the client for a run of a Runner with its own client or
+// middleware. The template's middleware wraps the Runner's client, the
+// Runner's middleware wraps both.
+func (tr *TemplateRequest) runnerClient() (*http.Client, error) {
+	if tr.runClient != nil {
+		return tr.runClient, nil
+	}
+
+	var client http.Client
+	if tr.runner.client != nil {
+		client = *tr.runner.client
+		rt := client.Transport
+		if rt == nil {
+			rt = http.DefaultTransport
+		}
+		var err error
+		if client.Transport, err = tr.wrapTransport(rt); err != nil {
+			return nil, err
+		}
+	} else {
+		base, err := tr.templateClient()
+		if err != nil {
+			return nil, err
+		}
+		client = *base
+	}
+
+	client.Transport = wrap(client.Transport, tr.runner.middleware)
+	tr.runClient = &client
+	return tr.runClient, nil
+}
+
+func (tr *TemplateRequest) httpRoundTripper() (*http.Transport, error) {
+	conn := tr.Connection
+	timeouts := tr.Timeouts
+
+	protocols, err := conn.protocols()
+	if err != nil {
+		return nil, err
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Protocols = protocols
+
+	if tr.proxyURL != nil {
+		transport.Proxy = http.ProxyURL(tr.proxyURL)
+	}
+	tlsConfig, err := tr.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig != nil {
+		transport.TLSClientConfig = tlsConfig
+	}
+
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+	if timeouts.Connect > 0 {
+		dialer.Timeout = time.Duration(timeouts.Connect)
+	}
+	if conn.KeepAlive > 0 {
+		dialer.KeepAlive = time.Duration(conn.KeepAlive)
+	}
+	transport.DialContext = dialer.DialContext
+
+	if timeouts.TLSHandshake > 0 {
+		transport.TLSHandshakeTimeout = time.Duration(timeouts.TLSHandshake)
+	}
+	if timeouts.ResponseHeader > 0 {
+		transport.ResponseHeaderTimeout = time.Duration(timeouts.ResponseHeader)
+	}
+
+	if conn.MaxIdleConns > 0 {
+		transport.MaxIdleConns = conn.MaxIdleConns
+	}
+	if conn.MaxIdleConnsPerHost > 0 {
+		transport.MaxIdleConnsPerHost = conn.MaxIdleConnsPerHost
+	}
+	transport.MaxConnsPerHost = conn.MaxConnsPerHost
+	if conn.IdleConnTimeout > 0 {
+		transport.IdleConnTimeout = time.Duration(conn.IdleConnTimeout)
+	}
+	transport.DisableKeepAlives = conn.DisableKeepAlives
+
+	return transport, nil
+}
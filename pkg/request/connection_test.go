@@ -0,0 +1,95 @@
+package request
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestConnectionReuse(t *testing.T) {
+	var conns atomic.Int32
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{}`))
+	}))
+	server.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			conns.Add(1)
+		}
+	}
+	server.Start()
+	defer server.Close()
+
+	tr := &TemplateRequest{Method: "GET", URL: server.URL, MaxIterations: 5}
+	tr.Recurse(&RequestContext{}, func(body []byte) {})
+
+	if conns.Load() != 1 {
+		t.Errorf("Expected one connection for five requests, got %d", conns.Load())
+	}
+}
+
+func protoServer(protocols *http.Protocols, tls bool) (*httptest.Server, *atomic.Int32) {
+	var major atomic.Int32
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		major.Store(int32(r.ProtoMajor))
+		w.Write([]byte(`{}`))
+	}))
+	server.Config.Protocols = protocols
+	if tls {
+		server.EnableHTTP2 = true
+		server.StartTLS()
+	} else {
+		server.Start()
+	}
+	return server, &major
+}
+
+func TestHTTPVersions(t *testing.T) {
+	h2c := &http.Protocols{}
+	h2c.SetHTTP1(true)
+	h2c.SetUnencryptedHTTP2(true)
+
+	tests := []struct {
+		name     string
+		version  string
+		tls      bool
+		expected int32
+	}{
+		{"h2c", HTTPVersionH2C, false, 2},
+		{"plain default", "", false, 1},
+		{"tls default", "", true, 2},
+		{"tls forced 1.1", HTTPVersion1, true, 1},
+		{"tls forced 2", HTTPVersion2, true, 2},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server, major := protoServer(h2c, test.tls)
+			defer server.Close()
+
+			tr := &TemplateRequest{Method: "GET", URL: server.URL, Connection: Connection{HTTPVersion: test.version}}
+			transport, err := tr.httpRoundTripper()
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			// trust the test server's certificate
+			transport.TLSClientConfig = server.Client().Transport.(*http.Transport).TLSClientConfig
+			tr.client = &http.Client{Transport: transport}
+
+			if _, _, err := tr.Send(&RequestContext{}); err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if major.Load() != test.expected {
+				t.Errorf("Expected HTTP/%d, got HTTP/%d", test.expected, major.Load())
+			}
+		})
+	}
+}
+
+func TestUnknownHTTPVersion(t *testing.T) {
+	tr := &TemplateRequest{Method: "GET", URL: "http://localhost", Connection: Connection{HTTPVersion: "3"}}
+	if _, _, err := tr.Send(&RequestContext{}); err == nil {
+		t.Error("Expected error for unknown http_version")
+	}
+}
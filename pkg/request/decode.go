@@ -0,0 +1,139 @@
+package request
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+	"golang.org/x/net/html/charset"
+)
+
+// decodeReader undoes the Content-Encoding of a body and converts it to
+// UTF-8 based on the Content-Type charset while it is read. The Go client
+// only decompresses gzip on its own when it added Accept-Encoding itself,
+// templates that set the header get the encoded bytes.
+//
+// When the body can't be decoded the error comes with a reader over the
+// body as received.
+func decodeReader(header http.Header, body io.Reader) (io.Reader, error) {
+	// decoders consume their headers while they are set up. try them on the
+	// start of the body first so a failure can still fall back to all of it
+	buffered := bufio.NewReaderSize(body, 4096)
+	prefix, _ := buffered.Peek(1024)
+	if _, err := decode(header, bytes.NewReader(prefix)); err != nil {
+		return buffered, err
+	}
+	return decode(header, buffered)
+}
+
+func decode(header http.Header, body io.Reader) (io.Reader, error) {
+	decoded, err := decompress(header.Get("Content-Encoding"), body)
+	if err != nil {
+		return nil, err
+	}
+
+	return toUTF8(header.Get("Content-Type"), decoded)
+}
+
+// decompress applies the decoders for a Content-Encoding list in reverse,
+// the order the server applied them.
+func decompress(contentEncoding string, body io.Reader) (io.Reader, error) {
+	encodings := strings.Split(contentEncoding, ",")
+	for i := len(encodings) - 1; i >= 0; i-- {
+		encoding := strings.ToLower(strings.TrimSpace(encodings[i]))
+		if encoding == "" || encoding == "identity" {
+			continue
+		}
+
+		reader, err := decompressor(encoding, body)
+		if err != nil {
+			return nil, fmt.Errorf("decoding %s body: %w", encoding, err)
+		}
+		body = reader
+	}
+	return body, nil
+}
+
+func decompressor(encoding string, body io.Reader) (io.Reader, error) {
+	switch encoding {
+	case "gzip", "x-gzip":
+		return gzip.NewReader(body)
+	case "deflate":
+		// deflate is supposed to be zlib wrapped, plenty of servers send raw
+		// deflate anyway. zlib streams start with 0x78.
+		buffered := bufio.NewReader(body)
+		if header, err := buffered.Peek(1); err == nil && header[0] == 0x78 {
+			return zlib.NewReader(buffered)
+		}
+		return flate.NewReader(buffered), nil
+	case "br":
+		return brotli.NewReader(body), nil
+	case "zstd":
+		r, err := zstd.NewReader(body, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return r.IOReadCloser(), nil
+	}
+	return nil, fmt.Errorf("unsupported content encoding %q", encoding)
+}
+
+// toUTF8 converts body to UTF-8. The charset comes from the Content-Type,
+// for HTML without one from a BOM or meta tag at the start of the document.
+// HTML that declares nothing is left as it is.
+func toUTF8(contentType string, body io.Reader) (io.Reader, error) {
+	mediaType, params, _ := mime.ParseMediaType(contentType)
+	label := strings.ToLower(params["charset"])
+
+	if label == "" {
+		if mediaType != "text/html" {
+			return body, nil
+		}
+		buffered := bufio.NewReader(body)
+		prefix, _ := buffered.Peek(1024)
+		label = sniffCharset(prefix)
+		body = buffered
+	}
+
+	if label == "" || label == "utf-8" || label == "utf8" || label == "us-ascii" {
+		return body, nil
+	}
+
+	reader, err := charset.NewReaderLabel(label, body)
+	if err != nil {
+		return nil, fmt.Errorf("decoding %s body: %w", label, err)
+	}
+	return reader, nil
+}
+
+// sniffCharset returns the charset a BOM or meta tag at the start of an HTML
+// document declares. Without one, DetermineEncoding guesses windows-1252
+// from an ASCII prefix, which mangles UTF-8 text further on. A UTF-8 rune
+// appended to the prefix makes it guess utf-8 instead, unless the prefix
+// itself is not UTF-8.
+func sniffCharset(prefix []byte) string {
+	prefix = prefix[:min(len(prefix), 1000)]
+	// drop a rune cut in half at the end
+	for i := len(prefix) - 1; i >= 0 && i >= len(prefix)-3; i-- {
+		if utf8.RuneStart(prefix[i]) {
+			if !utf8.FullRune(prefix[i:]) {
+				prefix = prefix[:i]
+			}
+			break
+		}
+	}
+
+	probe := append(bytes.Clone(prefix), "\u00e9 "...)
+	_, name, _ := charset.DetermineEncoding(probe, "")
+	return name
+}
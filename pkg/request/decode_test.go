@@ -0,0 +1,144 @@
+package request
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+func compress(t *testing.T, encoding string, body []byte) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch encoding {
+	case "gzip":
+		w = gzip.NewWriter(&buf)
+	case "deflate":
+		w = zlib.NewWriter(&buf)
+	case "raw-deflate":
+		w, _ = flate.NewWriter(&buf, flate.DefaultCompression)
+	case "br":
+		w = brotli.NewWriter(&buf)
+	case "zstd":
+		w, _ = zstd.NewWriter(&buf)
+	}
+	w.Write(body)
+	w.Close()
+	return buf.Bytes()
+}
+
+// readAll drains a decoding reader, passing through the error from setting
+// it up.
+func readAll(r io.Reader, err error) ([]byte, error) {
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+func TestDecompress(t *testing.T) {
+	body := []byte(`{"message": "hello"}`)
+
+	for _, encoding := range []string{"gzip", "deflate", "br", "zstd"} {
+		t.Run(encoding, func(t *testing.T) {
+			decoded, err := readAll(decompress(encoding, bytes.NewReader(compress(t, encoding, body))))
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if !bytes.Equal(decoded, body) {
+				t.Errorf("Expected %s, got %s", body, decoded)
+			}
+		})
+	}
+
+	t.Run("raw deflate", func(t *testing.T) {
+		decoded, err := readAll(decompress("deflate", bytes.NewReader(compress(t, "raw-deflate", body))))
+		if err != nil || !bytes.Equal(decoded, body) {
+			t.Errorf("Expected %s, got %s (%v)", body, decoded, err)
+		}
+	})
+
+	t.Run("stacked", func(t *testing.T) {
+		encoded := compress(t, "br", compress(t, "gzip", body))
+		decoded, err := readAll(decompress("gzip, br", bytes.NewReader(encoded)))
+		if err != nil || !bytes.Equal(decoded, body) {
+			t.Errorf("Expected %s, got %s (%v)", body, decoded, err)
+		}
+	})
+
+	t.Run("unsupported", func(t *testing.T) {
+		if _, err := decompress("compress", bytes.NewReader(body)); err == nil {
+			t.Error("Expected error for unsupported encoding")
+		}
+	})
+}
+
+func TestToUTF8(t *testing.T) {
+	latin1 := []byte("caf\xe9")
+
+	decoded, err := readAll(toUTF8("text/plain; charset=iso-8859-1", bytes.NewReader(latin1)))
+	if err != nil || string(decoded) != "café" {
+		t.Errorf("Expected café, got %q (%v)", decoded, err)
+	}
+
+	html := []byte(`<html><head><meta charset="windows-1252"></head><body>caf` + "\xe9" + `</body></html>`)
+	decoded, err = readAll(toUTF8("text/html", bytes.NewReader(html)))
+	if err != nil || !bytes.Contains(decoded, []byte("café")) {
+		t.Errorf("Expected sniffed charset to be decoded, got %q (%v)", decoded, err)
+	}
+
+	utf8HTML := []byte("<html><body>café</body></html>")
+	decoded, err = readAll(toUTF8("text/html", bytes.NewReader(utf8HTML)))
+	if err != nil || !bytes.Equal(decoded, utf8HTML) {
+		t.Errorf("Expected UTF-8 HTML to be left alone, got %q (%v)", decoded, err)
+	}
+
+	// the sniffed prefix is all ASCII
+	lateUTF8 := []byte("<html><body>" + strings.Repeat("a", 2000) + "café</body></html>")
+	decoded, err = readAll(toUTF8("text/html", bytes.NewReader(lateUTF8)))
+	if err != nil || !bytes.Equal(decoded, lateUTF8) {
+		t.Errorf("Expected UTF-8 after an ASCII prefix to be left alone, got %q (%v)", decoded[len(decoded)-20:], err)
+	}
+
+	bom := append([]byte("\xfe\xff"), 0, 'a')
+	decoded, err = readAll(toUTF8("text/html", bytes.NewReader(bom)))
+	if err != nil || strings.TrimPrefix(string(decoded), "\ufeff") != "a" {
+		t.Errorf("Expected a UTF-16 BOM to be decoded, got %q (%v)", decoded, err)
+	}
+}
+
+func TestHTTPDecodesBody(t *testing.T) {
+	body := []byte(`{"message": "hello"}`)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "br")
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(compress(t, "br", body))
+	}))
+	defer server.Close()
+
+	tr := &TemplateRequest{
+		Method:           "GET",
+		URL:              server.URL,
+		Headers:          map[string]string{"Accept-Encoding": "br"},
+		KeepOriginalBody: true,
+	}
+
+	tr.Recurse(&RequestContext{}, func(b []byte) {})
+
+	if tr.LastResponse.RawBody != string(body) {
+		t.Errorf("Expected decoded body, got %q", tr.LastResponse.RawBody)
+	}
+	if bytes.Equal(tr.LastResponse.OriginalBody, body) || len(tr.LastResponse.OriginalBody) == 0 {
+		t.Errorf("Expected the compressed original body to be kept")
+	}
+}
@@ -0,0 +1,91 @@
+package request
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"os"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+// dumpOptions configure the dump middleware.
+//
+//	middleware:
+//	  - name: dump
+//	    file: dump.txt
+//	    body: false
+type dumpOptions struct {
+	// File is appended to, stderr when empty.
+	File string `yaml:"file"`
+	// Body includes request and response bodies, it defaults to true.
+	Body *bool `yaml:"body"`
+}
+
+// newDumpMiddleware writes every request and response as they go over the
+// wire.
+func newDumpMiddleware(tr *TemplateRequest, options *yaml.Node) (Middleware, error) {
+	var opts dumpOptions
+	if err := decodeOptions(options, &opts); err != nil {
+		return nil, err
+	}
+	body := opts.Body == nil || *opts.Body
+
+	var w io.Writer = os.Stderr
+	if opts.File != "" {
+		w = appendFile(opts.File)
+	}
+
+	return DumpMiddleware(w, body), nil
+}
+
+// appendFile is a writer that appends to a file, opening it for each write
+// so nothing is left open once the run is over.
+type appendFile string
+
+func (f appendFile) Write(p []byte) (int, error) {
+	file, err := os.OpenFile(string(f), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return 0, err
+	}
+	n, err := file.Write(p)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return n, err
+}
+
+// DumpMiddleware writes every request and response to w, with their bodies
+// if body is set.
+func DumpMiddleware(w io.Writer, body bool) Middleware {
+	var mu sync.Mutex
+	return func(next http.RoundTripper) http.RoundTripper {
+		return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			reqDump, err := httputil.DumpRequestOut(req, body)
+			if err != nil {
+				return nil, err
+			}
+
+			resp, err := next.RoundTrip(req)
+			if err != nil {
+				mu.Lock()
+				fmt.Fprintf(w, "%s\n\n# error: %v\n\n", reqDump, err)
+				mu.Unlock()
+				return nil, err
+			}
+
+			respDump, err := httputil.DumpResponse(resp, body)
+			if err != nil {
+				resp.Body.Close()
+				return nil, err
+			}
+
+			mu.Lock()
+			fmt.Fprintf(w, "%s\n\n%s\n\n", reqDump, respDump)
+			mu.Unlock()
+			return resp, nil
+		})
+	}
+}
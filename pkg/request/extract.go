@@ -0,0 +1,28 @@
+package request
+
+import (
+	"maps"
+	"slices"
+)
+
+// extract runs the extract expressions against the response and stores the
+// first result of each in c.Extra, where the next request's templates
+// (.Extra.name) and conditions ($extra.name) can use it.
+func (tr *TemplateRequest) extract(c *RequestContext, in jqInput) {
+	if c.Extra == nil {
+		c.Extra = map[string]interface{}{}
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(tr.extractCodes)) {
+		iter := tr.extractCodes[name].Run(in.value, in.vars...)
+		v, ok := iter.Next()
+		if !ok {
+			continue
+		}
+		if err, ok := v.(error); ok {
+			tr.logger().Printf("error extracting %s: %v", name, err)
+			continue
+		}
+		c.Extra[name] = v
+	}
+}
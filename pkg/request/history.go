@@ -0,0 +1,58 @@
+package request
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+func hashBody(body []byte) string {
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+// repeated reports whether sr has the same body as the response before it
+// or, with a history window, any response in it. previous is the body hash
+// of the response before sr.
+func (tr *TemplateRequest) repeated(c *RequestContext, previous string, sr *SimpleResponse) bool {
+	if previous != "" && previous == sr.BodyHash {
+		return true
+	}
+	if c == nil {
+		return false
+	}
+	for _, old := range c.History {
+		if old.BodyHash == sr.BodyHash {
+			return true
+		}
+	}
+	return false
+}
+
+// pushHistory adds sr to the front of the history window and drops what no
+// longer fits.
+func (tr *TemplateRequest) pushHistory(c *RequestContext, sr *SimpleResponse) {
+	if tr.History <= 0 || c == nil {
+		return
+	}
+
+	history := make([]*SimpleResponse, 0, tr.History)
+	history = append(history, sr)
+	for _, old := range c.History {
+		if len(history) == tr.History {
+			break
+		}
+		history = append(history, old)
+	}
+	c.History = history
+}
+
+// stopOnRepeat logs why a run stops because a body came back again. It only
+// applies to pagination, list entries are expected to get the same reply.
+func (tr *TemplateRequest) stopOnRepeat(c *RequestContext, previous string, sr *SimpleResponse) bool {
+	if !tr.StopOnRepeat || len(tr.Lists) > 0 || !tr.repeated(c, previous, sr) {
+		return false
+	}
+
+	tr.logger().Printf("stopping: response body repeated (%s)", sr.BodyHash[:12])
+	return true
+}
@@ -0,0 +1,96 @@
+package request
+
+import (
+	"testing"
+)
+
+func TestHistoryWindow(t *testing.T) {
+	RegisterTransport("echo-history", func(tr *TemplateRequest) (Transport, error) { return &echoTransport{}, nil })
+
+	tr := &TemplateRequest{
+		URL:      "echo-history://local",
+		Body:     `{"page": {{.Page}}, "seen": "{{range .History}}{{.BodyObject.page}}{{end}}"}`,
+		History:  2,
+		StopWhen: []string{`select([$history[].body_object.page] == [3, 2]) | .`},
+	}
+
+	c := &RequestContext{}
+	bodies := []string{}
+	tr.Recurse(c, func(body []byte) { bodies = append(bodies, string(body)) })
+
+	if len(bodies) != 4 {
+		t.Fatalf("Expected to stop on page 4, got %v", bodies)
+	}
+	if bodies[3] != `{"page": 4, "seen": "32"}` {
+		t.Errorf("Expected the template to see the last two responses, got %s", bodies[3])
+	}
+	if len(c.History) != 2 || c.History[0].BodyObject.(map[string]any)["page"] != 4.0 {
+		t.Errorf("Expected the newest response first in a window of 2, got %d entries", len(c.History))
+	}
+}
+
+func TestStopOnRepeat(t *testing.T) {
+	RegisterTransport("echo-repeat", func(tr *TemplateRequest) (Transport, error) { return &echoTransport{}, nil })
+
+	tr := &TemplateRequest{
+		URL:           "echo-repeat://local",
+		Body:          `{{if lt .Page 3}}{{.Page}}{{else}}last{{end}}`,
+		StopOnRepeat:  true,
+		MaxIterations: 10,
+	}
+
+	bodies := []string{}
+	tr.Recurse(&RequestContext{}, func(body []byte) { bodies = append(bodies, string(body)) })
+
+	// the repeated last page is not reported again
+	if len(bodies) != 3 || bodies[2] != "last" {
+		t.Errorf("Expected to stop once the last page repeated, got %v", bodies)
+	}
+}
+
+func TestStopOnRepeatWithinHistory(t *testing.T) {
+	RegisterTransport("echo-cycle", func(tr *TemplateRequest) (Transport, error) { return &echoTransport{}, nil })
+
+	tr := &TemplateRequest{
+		URL:           "echo-cycle://local",
+		Body:          `{{if eq (printf "%d" .Page) "1" "3"}}a{{else}}b{{end}}`,
+		History:       3,
+		StopOnRepeat:  true,
+		MaxIterations: 10,
+	}
+
+	bodies := []string{}
+	tr.Recurse(&RequestContext{}, func(body []byte) { bodies = append(bodies, string(body)) })
+
+	// a, b, a: the third body was seen two responses ago
+	if len(bodies) != 2 || tr.LastResponse.RawBody != "a" {
+		t.Errorf("Expected to stop when a body in the window came back, got %v", bodies)
+	}
+}
+
+func TestStopOnRepeatScope(t *testing.T) {
+	RegisterTransport("echo-scope", func(tr *TemplateRequest) (Transport, error) { return &echoTransport{}, nil })
+
+	tr := &TemplateRequest{
+		URL:          "echo-scope://local",
+		Body:         `{{.Page}}`,
+		StopOnRepeat: true,
+	}
+	bodies := []string{}
+	tr.Recurse(&RequestContext{}, func(body []byte) { bodies = append(bodies, string(body)) })
+	if len(bodies) != 1 {
+		t.Errorf("Expected stop_on_repeat not to keep a run without conditions going, got %v", bodies)
+	}
+
+	tr = &TemplateRequest{
+		URL:          "echo-scope://local",
+		Body:         `denied`,
+		Lists:        [][]string{{"a", "b", "c"}},
+		StopOnRepeat: true,
+	}
+	bodies = []string{}
+	tr.Recurse(&RequestContext{}, func(body []byte) { bodies = append(bodies, string(body)) })
+	if len(bodies) != 3 {
+		t.Errorf("Expected every list entry to be sent despite repeated bodies, got %v", bodies)
+	}
+}
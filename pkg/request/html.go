@@ -0,0 +1,150 @@
+package request
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/andybalholm/cascadia"
+	"github.com/antchfx/htmlquery"
+	"github.com/itchyny/gojq"
+	"golang.org/x/net/html"
+)
+
+// htmlVariable is a hidden jq variable holding the htmlDoc of the response
+// being evaluated, htmlPrelude passes it on to the HTML jq functions.
+const htmlVariable = "$__html"
+
+// htmlPrelude defines the HTML jq functions on top of their implementations,
+// which take the htmlDoc as an extra argument.
+var htmlPrelude = []*gojq.FuncDef{
+	mustParseFuncDef(`def css($s): _css($s; $__html);`),
+	mustParseFuncDef(`def css_attr($s; $a): _css_attr($s; $a; $__html);`),
+	mustParseFuncDef(`def xpath($e): _xpath($e; $__html);`),
+}
+
+func mustParseFuncDef(def string) *gojq.FuncDef {
+	query, err := gojq.Parse(def + " .")
+	if err != nil {
+		panic(err)
+	}
+	return query.FuncDefs[0]
+}
+
+// htmlDoc keeps the last document parsed for a response, conditions and
+// extraction rules usually query the same body several times in a row.
+type htmlDoc struct {
+	body string
+	doc  *html.Node
+}
+
+// htmlDocument parses the input of an HTML jq function: either a string or
+// a response, in which case its raw_body is used. cache is the htmlDoc of
+// the response being evaluated, if any.
+func htmlDocument(v any, cache any, fn string) (*html.Node, error) {
+	var body string
+	switch v := v.(type) {
+	case string:
+		body = v
+	case map[string]any:
+		body, _ = v["raw_body"].(string)
+	default:
+		return nil, fmt.Errorf("%s: input must be a response or a string, got %T", fn, v)
+	}
+
+	hd, _ := cache.(*htmlDoc)
+	if hd != nil && hd.doc != nil && hd.body == body {
+		return hd.doc, nil
+	}
+
+	doc, err := html.Parse(strings.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fn, err)
+	}
+	if hd != nil {
+		hd.body = body
+		hd.doc = doc
+	}
+	return doc, nil
+}
+
+func cssSelect(v any, args []any, fn string) ([]*html.Node, error) {
+	selector, ok := args[0].(string)
+	if !ok {
+		return nil, fmt.Errorf("%s: selector must be a string, got %T", fn, args[0])
+	}
+
+	sel, err := cascadia.Parse(selector)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fn, err)
+	}
+
+	doc, err := htmlDocument(v, args[len(args)-1], fn)
+	if err != nil {
+		return nil, err
+	}
+	return cascadia.QueryAll(doc, sel), nil
+}
+
+// jqCSS implements css($selector), the text of every element matching a CSS
+// selector.
+func jqCSS(v any, args []any) any {
+	nodes, err := cssSelect(v, args, "css")
+	if err != nil {
+		return err
+	}
+
+	texts := make([]any, len(nodes))
+	for i, node := range nodes {
+		texts[i] = strings.TrimSpace(htmlquery.InnerText(node))
+	}
+	return texts
+}
+
+// jqCSSAttr implements css_attr($selector; $attr), the attribute of every
+// element matching a CSS selector that has it.
+func jqCSSAttr(v any, args []any) any {
+	nodes, err := cssSelect(v, args, "css_attr")
+	if err != nil {
+		return err
+	}
+
+	name, ok := args[1].(string)
+	if !ok {
+		return fmt.Errorf("css_attr: attribute must be a string, got %T", args[1])
+	}
+
+	values := []any{}
+	for _, node := range nodes {
+		for _, attr := range node.Attr {
+			if attr.Key == name {
+				values = append(values, attr.Val)
+			}
+		}
+	}
+	return values
+}
+
+// jqXPath implements xpath($expr), the text of every node matching an XPath
+// expression. Attribute nodes give their value.
+func jqXPath(v any, args []any) any {
+	expr, ok := args[0].(string)
+	if !ok {
+		return fmt.Errorf("xpath: expression must be a string, got %T", args[0])
+	}
+
+	doc, err := htmlDocument(v, args[1], "xpath")
+	if err != nil {
+		return err
+	}
+
+	nodes, err := htmlquery.QueryAll(doc, expr)
+	if err != nil {
+		return fmt.Errorf("xpath: %w", err)
+	}
+
+	texts := make([]any, len(nodes))
+	for i, node := range nodes {
+		texts[i] = strings.TrimSpace(htmlquery.InnerText(node))
+	}
+	return texts
+}
@@ -0,0 +1,115 @@
+package request
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"time"
+)
+
+// httpTransport sends requests with the template's shared http.Client.
+type httpTransport struct {
+	tr *TemplateRequest
+}
+
+func newHTTPTransport(tr *TemplateRequest) (Transport, error) {
+	return &httpTransport{tr: tr}, nil
+}
+
+func (t *httpTransport) RoundTrip(ctx context.Context, c *RequestContext, rendered *RenderedRequest) (*SimpleResponse, error) {
+	if t.tr.Timeouts.Request > 0 {
+		// cancelled once the body is read, so the timeout covers it too
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(t.tr.Timeouts.Request))
+		defer cancel()
+	}
+
+	var redirects []Redirect
+	ctx = withRedirectChain(ctx, &redirects)
+	trace := newRequestTrace()
+	ctx = httptrace.WithClientTrace(ctx, trace.clientTrace())
+
+	req, err := http.NewRequestWithContext(ctx, rendered.Method, rendered.URL.String(), bytes.NewReader(rendered.Body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header = rendered.Header
+	for _, h := range t.tr.hooks() {
+		if h.BeforeRequest != nil {
+			if err := h.BeforeRequest(ctx, c, req); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	client, err := t.tr.httpClient()
+	if err != nil {
+		return nil, err
+	}
+
+	trace.start = time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	sr := &SimpleResponse{
+		Request: SimpleRequest{
+			Path:   resp.Request.URL.Path,
+			Query:  resp.Request.URL.Query(),
+			Method: resp.Request.Method,
+		},
+		Status:      resp.StatusCode,
+		ContentType: resp.Header.Get("Content-Type"),
+		Headers:     resp.Header,
+		Redirects:   redirects,
+		Cookies:     newCookies(resp.Header),
+		RemoteAddr:  trace.remoteAddr,
+		Proto:       resp.Proto,
+		TLS:         newTLSInfo(resp.TLS),
+		http:        resp,
+	}
+
+	var raw io.Reader = resp.Body
+	var original *prefixWriter
+	if t.tr.KeepOriginalBody {
+		original = &prefixWriter{max: -1}
+		if t.tr.MaxBodySize > 0 {
+			original.max = int64(t.tr.MaxBodySize)
+		}
+		raw = io.TeeReader(raw, original)
+	}
+
+	body := raw
+	if !t.tr.DisableDecoding {
+		if body, err = decodeReader(resp.Header, raw); err != nil {
+			t.tr.logger().Println(err)
+		}
+	}
+
+	if err := t.tr.readBody(c, sr, body); err != nil {
+		return nil, err
+	}
+	end := time.Now()
+	sr.Timing = trace.timing(end)
+	sr.TimeMS = end.Sub(trace.start).Milliseconds()
+	if original != nil {
+		sr.OriginalBody = original.buf.Bytes()
+	}
+	return sr, nil
+}
+
+// Close drops the idle connections of the template's client, the client
+// itself is kept for the next run.
+func (t *httpTransport) Close() error {
+	if t.tr.baseTransport != nil {
+		// middleware hides the transport from client.CloseIdleConnections
+		t.tr.baseTransport.CloseIdleConnections()
+	} else if t.tr.client != nil {
+		t.tr.client.CloseIdleConnections()
+	}
+	return nil
+}
@@ -0,0 +1,250 @@
+package request
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// replaceTag on a value in a template replaces the inherited value instead
+// of merging with it, e.g. stop_when: !replace ['.status == 404'].
+const replaceTag = "!replace"
+
+// conditionKeys are the fields whose lists are appended to the inherited
+// ones instead of replacing them.
+var conditionKeys = []string{"stop_when", "continue_while", "stop_unless"}
+
+// templateLoader reads templates with their extends and include files.
+type templateLoader struct {
+	// stack holds the files being loaded, to detect cycles
+	stack []string
+}
+
+// loadFile reads a template file and everything it extends or includes,
+// merged into one node. Files with more than one template can not be loaded
+// this way.
+func (l *templateLoader) loadFile(filename string) (*yaml.Node, error) {
+	nodes, err := l.loadFileAll(filename)
+	if err != nil {
+		return nil, err
+	}
+	return single(filename, nodes)
+}
+
+// loadFileAll is loadFile for every template in a multi-document file.
+func (l *templateLoader) loadFileAll(filename string) ([]*yaml.Node, error) {
+	abs, err := filepath.Abs(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	if slices.Contains(l.stack, abs) {
+		chain := append(slices.Clone(l.stack[slices.Index(l.stack, abs):]), abs)
+		for i, path := range chain {
+			chain[i] = filepath.Base(path)
+		}
+		return nil, fmt.Errorf("template cycle: %s", strings.Join(chain, " -> "))
+	}
+	l.stack = append(l.stack, abs)
+	defer func() { l.stack = l.stack[:len(l.stack)-1] }()
+
+	data, err := os.ReadFile(abs)
+	if err != nil {
+		return nil, err
+	}
+
+	nodes, err := l.loadAll(data, filepath.Dir(abs))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	return nodes, nil
+}
+
+// load parses a template and merges it over the files it extends and
+// includes, which are looked up relative to dir. The base it extends comes
+// first, then the includes in order and the template itself last.
+func (l *templateLoader) load(data []byte, dir string) (*yaml.Node, error) {
+	nodes, err := l.loadAll(data, dir)
+	if err != nil {
+		return nil, err
+	}
+	return single("template", nodes)
+}
+
+// loadAll is load for every document of a multi-document YAML file.
+func (l *templateLoader) loadAll(data []byte, dir string) ([]*yaml.Node, error) {
+	var nodes []*yaml.Node
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	for {
+		var doc yaml.Node
+		if err := decoder.Decode(&doc); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nodes, nil
+			}
+			return nil, err
+		}
+		if len(doc.Content) == 0 || doc.Content[0].ShortTag() == "!!null" {
+			// empty documents, e.g. after a trailing ---
+			continue
+		}
+
+		node, err := l.resolve(doc.Content[0], dir)
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, node)
+	}
+}
+
+func single(name string, nodes []*yaml.Node) (*yaml.Node, error) {
+	switch len(nodes) {
+	case 0:
+		return nil, nil
+	case 1:
+		return nodes[0], nil
+	}
+	return nil, fmt.Errorf("%s holds %d templates, expected one", name, len(nodes))
+}
+
+// resolve merges root over what it extends and includes.
+func (l *templateLoader) resolve(root *yaml.Node, dir string) (*yaml.Node, error) {
+	if root.Kind != yaml.MappingNode {
+		return root, nil
+	}
+
+	var files []string
+	extends := takeKey(root, "extends")
+	if extends != nil {
+		if extends.Kind != yaml.ScalarNode {
+			return nil, fmt.Errorf("line %d: extends must be a file name", extends.Line)
+		}
+		files = append(files, extends.Value)
+	}
+	if include := takeKey(root, "include"); include != nil {
+		var names []string
+		if include.Kind == yaml.ScalarNode {
+			names = []string{include.Value}
+		} else if err := include.Decode(&names); err != nil {
+			return nil, err
+		}
+		files = append(files, names...)
+	}
+
+	var merged *yaml.Node
+	for _, name := range files {
+		if !filepath.IsAbs(name) {
+			name = filepath.Join(dir, name)
+		}
+		node, err := l.loadFile(name)
+		if err != nil {
+			return nil, err
+		}
+		if merged, err = mergeNodes(merged, node, "", false); err != nil {
+			return nil, err
+		}
+	}
+
+	return mergeNodes(merged, root, "", false)
+}
+
+// takeKey removes key from a mapping node and returns its value.
+func takeKey(mapping *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			value := mapping.Content[i+1]
+			mapping.Content = slices.Delete(mapping.Content, i, i+2)
+			return value
+		}
+	}
+	return nil
+}
+
+// mergeNodes merges child over parent. Mappings are merged key by key, the
+// keys of headers regardless of case. Lists are replaced, except condition
+// lists which are appended. Anything else in child replaces parent, as does
+// a child value tagged !replace.
+func mergeNodes(parent, child *yaml.Node, key string, appendLists bool) (*yaml.Node, error) {
+	if child != nil && child.Tag == replaceTag {
+		return child, nil
+	}
+	if parent == nil {
+		return child, nil
+	}
+	if child == nil {
+		return parent, nil
+	}
+
+	if slices.Contains(conditionKeys, key) {
+		appendLists = true
+		if parent.Kind == yaml.ScalarNode {
+			parent = sequenceOf(parent)
+		}
+		if child.Kind == yaml.ScalarNode {
+			child = sequenceOf(child)
+		}
+		if parent.Kind != child.Kind {
+			return nil, fmt.Errorf("line %d: can not merge %s with the inherited conditions, use %s to replace them", child.Line, key, replaceTag)
+		}
+	}
+
+	switch {
+	case parent.Kind == yaml.MappingNode && child.Kind == yaml.MappingNode:
+		merged := *parent
+		merged.Content = slices.Clone(parent.Content)
+		for i := 0; i+1 < len(child.Content); i += 2 {
+			childKey, childValue := child.Content[i], child.Content[i+1]
+
+			found := false
+			for j := 0; j+1 < len(merged.Content); j += 2 {
+				parentKey := merged.Content[j]
+				if parentKey.Value != childKey.Value && !(key == "headers" && strings.EqualFold(parentKey.Value, childKey.Value)) {
+					continue
+				}
+
+				value, err := mergeNodes(merged.Content[j+1], childValue, childKey.Value, appendLists)
+				if err != nil {
+					return nil, err
+				}
+				merged.Content[j], merged.Content[j+1] = childKey, value
+				found = true
+				break
+			}
+			if !found {
+				merged.Content = append(merged.Content, childKey, childValue)
+			}
+		}
+		return &merged, nil
+
+	case appendLists && parent.Kind == yaml.SequenceNode && child.Kind == yaml.SequenceNode:
+		merged := *child
+		merged.Content = slices.Concat(parent.Content, child.Content)
+		return &merged, nil
+	}
+
+	return child, nil
+}
+
+// clearReplaceTags drops the !replace tags once everything is merged, so
+// the values decode as usual.
+func clearReplaceTags(node *yaml.Node) {
+	if node == nil {
+		return
+	}
+	if node.Tag == replaceTag {
+		node.Tag = ""
+	}
+	for _, n := range node.Content {
+		clearReplaceTags(n)
+	}
+}
+
+func sequenceOf(node *yaml.Node) *yaml.Node {
+	return &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq", Line: node.Line, Column: node.Column, Content: []*yaml.Node{node}}
+}
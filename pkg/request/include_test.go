@@ -0,0 +1,168 @@
+package request
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeTemplates(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+	}
+	return dir
+}
+
+func TestExtends(t *testing.T) {
+	dir := writeTemplates(t, map[string]string{
+		"base/api.yaml": `
+url: https://{{.Host}}/api/v1
+method: GET
+headers:
+  Authorization: Bearer {{.AuthToken}}
+  Accept: application/json
+timeouts:
+  connect: 5s
+  request: 30s
+stop_when:
+  - 'select(.status >= 500) | .'
+max_iterations: 50
+`,
+		"users.yaml": `
+extends: base/api.yaml
+url: https://{{.Host}}/api/v1/users?page={{.Page}}
+headers:
+  accept: application/xml
+  X-Team: core
+timeouts:
+  request: 10s
+stop_when:
+  - 'select(.body_object.users == []) | .'
+`,
+	})
+
+	tr, err := FromFile(filepath.Join(dir, "users.yaml"))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if tr.URL != "https://{{.Host}}/api/v1/users?page={{.Page}}" || tr.Method != "GET" || tr.MaxIterations != 50 {
+		t.Errorf("Expected fields to be inherited and overridden, got %s %s %d", tr.Method, tr.URL, tr.MaxIterations)
+	}
+
+	if len(tr.Headers) != 3 || tr.Headers["accept"] != "application/xml" || tr.Headers["X-Team"] != "core" || tr.Headers["Authorization"] == "" {
+		t.Errorf("Expected headers merged regardless of case, got %v", tr.Headers)
+	}
+
+	if time.Duration(tr.Timeouts.Connect) != 5*time.Second || time.Duration(tr.Timeouts.Request) != 10*time.Second {
+		t.Errorf("Expected timeouts merged, got %+v", tr.Timeouts)
+	}
+
+	if len(tr.StopWhen) != 2 || !strings.Contains(tr.StopWhen[0], "status >= 500") {
+		t.Errorf("Expected inherited conditions first, got %v", tr.StopWhen)
+	}
+}
+
+func TestInclude(t *testing.T) {
+	dir := writeTemplates(t, map[string]string{
+		"base.yaml":    "url: http://{{.Host}}/\nmethod: GET\nstop_when: 'select(.status == 429) | .'\n",
+		"auth.yaml":    "headers:\n  Authorization: Bearer {{.AuthToken}}\n",
+		"headers.yaml": "headers:\n  authorization: Basic x\n  User-Agent: requrse\n",
+		"search.yaml": `
+extends: base.yaml
+include: [auth.yaml, headers.yaml]
+method: POST
+stop_when: !replace
+  any:
+    - 'select(.status == 404) | .'
+`,
+	})
+
+	tr, err := FromFile(filepath.Join(dir, "search.yaml"))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if tr.Method != "POST" || tr.URL != "http://{{.Host}}/" {
+		t.Errorf("Expected the base with overrides, got %s %s", tr.Method, tr.URL)
+	}
+	if len(tr.Headers) != 2 || tr.Headers["authorization"] != "Basic x" {
+		t.Errorf("Expected the later include to win, got %v", tr.Headers)
+	}
+	if len(tr.StopWhen) != 0 || len(tr.Combinators.StopWhen.Any) != 1 {
+		t.Errorf("Expected !replace to drop the inherited conditions, got %v %+v", tr.StopWhen, tr.Combinators.StopWhen)
+	}
+}
+
+func TestIncludeConditionGroups(t *testing.T) {
+	dir := writeTemplates(t, map[string]string{
+		"base.yaml":  "continue_while:\n  all: ['.body_object.has_more']\n",
+		"list.yaml":  "extends: base.yaml\ncontinue_while:\n  all: ['.status == 200']\n  none: ['.body_object.error']\n",
+		"mixed.yaml": "extends: base.yaml\ncontinue_while: ['.status == 200']\n",
+	})
+
+	tr, err := FromFile(filepath.Join(dir, "list.yaml"))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(tr.Combinators.ContinueWhile.All) != 2 || len(tr.Combinators.ContinueWhile.None) != 1 {
+		t.Errorf("Expected all lists appended, got %+v", tr.Combinators.ContinueWhile)
+	}
+
+	if _, err := FromFile(filepath.Join(dir, "mixed.yaml")); err == nil || !strings.Contains(err.Error(), "!replace") {
+		t.Errorf("Expected an error merging a plain list with groups, got %v", err)
+	}
+}
+
+func TestExtendsCycle(t *testing.T) {
+	dir := writeTemplates(t, map[string]string{
+		"a.yaml": "extends: b.yaml\nurl: http://a\n",
+		"b.yaml": "include: [c.yaml]\n",
+		"c.yaml": "extends: a.yaml\n",
+		"d.yaml": "extends: d.yaml\n",
+	})
+
+	_, err := FromFile(filepath.Join(dir, "a.yaml"))
+	if err == nil || !strings.Contains(err.Error(), "template cycle: a.yaml -> b.yaml -> c.yaml -> a.yaml") {
+		t.Errorf("Expected a cycle error, got %v", err)
+	}
+
+	if _, err := FromFile(filepath.Join(dir, "d.yaml")); err == nil || !strings.Contains(err.Error(), "template cycle") {
+		t.Errorf("Expected a cycle error for a template extending itself, got %v", err)
+	}
+}
+
+func TestIncludeDiamond(t *testing.T) {
+	dir := writeTemplates(t, map[string]string{
+		"common.yaml": "headers:\n  Accept: application/json\n",
+		"a.yaml":      "include: common.yaml\nmethod: GET\n",
+		"b.yaml":      "include: common.yaml\nurl: http://b\n",
+		"c.yaml":      "include: [a.yaml, b.yaml]\n",
+	})
+
+	tr, err := FromFile(filepath.Join(dir, "c.yaml"))
+	if err != nil {
+		t.Fatalf("Expected no error including a file twice, got %v", err)
+	}
+	if tr.Method != "GET" || tr.URL != "http://b" || tr.Headers["Accept"] != "application/json" {
+		t.Errorf("Expected both includes merged, got %s %s %v", tr.Method, tr.URL, tr.Headers)
+	}
+}
+
+func TestExtendsMissing(t *testing.T) {
+	dir := writeTemplates(t, map[string]string{"a.yaml": "extends: missing.yaml\n"})
+
+	if _, err := FromFile(filepath.Join(dir, "a.yaml")); err == nil || !strings.Contains(err.Error(), "missing.yaml") {
+		t.Errorf("Expected an error naming the missing file, got %v", err)
+	}
+}
@@ -0,0 +1,437 @@
+package request
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/itchyny/gojq"
+)
+
+// jqVariableNames are the request context fields available to conditions
+// and jq matchers, in the order jqVariables returns their values.
+var jqVariableNames = []string{
+	"$iteration",
+	"$page",
+	"$page_size",
+	"$offset",
+	"$host",
+	"$auth_token",
+	"$list",
+	"$extra",
+	"$history",
+}
+
+// jqInput is what a compiled expression runs against: the response and the
+// values for jqVariableNames followed by the htmlVariable.
+type jqInput struct {
+	value any
+	vars  []any
+}
+
+func newJQInput(c *RequestContext, sr *SimpleResponse) jqInput {
+	return jqInput{value: sr.jqValue(), vars: sr.jqVariables(c)}
+}
+
+// jqVariables is jqVariables with the htmlDoc of sr, so the HTML jq functions
+// parse its body once.
+func (sr *SimpleResponse) jqVariables(c *RequestContext) []any {
+	vars := jqVariables(c)
+	vars[len(vars)-1] = sr.html
+	return vars
+}
+
+// jqVariables returns the values for jqVariableNames and an empty
+// htmlVariable.
+func jqVariables(c *RequestContext) []any {
+	if c == nil {
+		return make([]any, len(jqVariableNames)+1)
+	}
+
+	list := make([]any, len(c.ListParams))
+	for i, param := range c.ListParams {
+		list[i] = param
+	}
+
+	var extra any
+	if c.Extra != nil {
+		extra = toJQ(map[string]any(c.Extra))
+	}
+
+	history := make([]any, len(c.History))
+	for i, sr := range c.History {
+		history[i] = sr.jqValue()
+	}
+
+	return []any{
+		c.Iteration,
+		c.Page,
+		c.PageSize,
+		c.ResultOffset,
+		c.Host,
+		c.AuthToken,
+		list,
+		extra,
+		history,
+		nil,
+	}
+}
+
+// compileJQ parses and compiles an expression that runs against responses.
+func compileJQ(expr string) (*gojq.Code, error) {
+	query, err := gojq.Parse(expr)
+	if err != nil {
+		return nil, fmt.Errorf("parsing jq %q: %w", expr, err)
+	}
+
+	query.FuncDefs = append(slices.Clone(htmlPrelude), query.FuncDefs...)
+	code, err := gojq.Compile(query,
+		gojq.WithVariables(append(slices.Clone(jqVariableNames), htmlVariable)),
+		gojq.WithFunction("header", 1, 1, jqHeader),
+		gojq.WithFunction("cookie", 1, 1, jqCookie),
+		gojq.WithFunction("_css", 2, 2, jqCSS),
+		gojq.WithFunction("_css_attr", 3, 3, jqCSSAttr),
+		gojq.WithFunction("_xpath", 2, 2, jqXPath),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("compiling jq %q: %w", expr, err)
+	}
+	return code, nil
+}
+
+func compileJQAll(exprs []string) ([]*gojq.Code, error) {
+	codes := make([]*gojq.Code, 0, len(exprs))
+	for _, expr := range exprs {
+		code, err := compileJQ(expr)
+		if err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+	}
+	return codes, nil
+}
+
+// Compile compiles every jq expression and regex in the template. FromBytes
+// calls it, templates built in code are compiled on first use.
+func (tr *TemplateRequest) Compile() error {
+	tr.compileOnce.Do(func() {
+		if tr.compileErr = checkBodyFormat(tr.BodyFormat); tr.compileErr != nil {
+			return
+		}
+		if tr.compileErr = checkOnOversize(tr.OnOversize); tr.compileErr != nil {
+			return
+		}
+		if tr.stopWhen, tr.compileErr = compileConditions(tr.StopWhen, tr.Combinators.StopWhen); tr.compileErr != nil {
+			return
+		}
+		if tr.continueWhile, tr.compileErr = compileConditions(tr.ContinueWhile, tr.Combinators.ContinueWhile); tr.compileErr != nil {
+			return
+		}
+		if tr.stopUnless, tr.compileErr = compileConditions(tr.StopUnless, tr.Combinators.StopUnless); tr.compileErr != nil {
+			return
+		}
+		if tr.extractCodes, tr.compileErr = compileJQMap(tr.Extract); tr.compileErr != nil {
+			return
+		}
+		for _, m := range []*Matcher{tr.Match, tr.Filter} {
+			if m == nil {
+				continue
+			}
+			if tr.compileErr = m.Compile(); tr.compileErr != nil {
+				return
+			}
+		}
+		tr.compileErr = tr.compileCollect()
+	})
+	return tr.compileErr
+}
+
+func compileJQMap(exprs map[string]string) (map[string]*gojq.Code, error) {
+	codes := make(map[string]*gojq.Code, len(exprs))
+	for name, expr := range exprs {
+		code, err := compileJQ(expr)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		codes[name] = code
+	}
+	return codes, nil
+}
+
+// compiled compiles the template along with matchers and a collect
+// expression set after it was compiled, so invalid expressions surface as
+// errors of Send.
+func (tr *TemplateRequest) compiled() error {
+	if err := tr.Compile(); err != nil {
+		return err
+	}
+	// on_oversize may be set after loading, by a flag for one
+	if err := checkOnOversize(tr.OnOversize); err != nil {
+		return err
+	}
+	for _, m := range []*Matcher{tr.Match, tr.Filter} {
+		if m != nil && !m.compiled {
+			if err := m.Compile(); err != nil {
+				return err
+			}
+		}
+	}
+	if tr.Collect != "" && tr.collectCode == nil {
+		return tr.compileCollect()
+	}
+	return nil
+}
+
+// jqValue converts sr into the generic form gojq runs against. It builds the
+// same shape json.Marshal would produce without the round trip through JSON.
+func (sr *SimpleResponse) jqValue() map[string]any {
+	return map[string]any{
+		"request":      sr.Request.jqValue(),
+		"status":       sr.Status,
+		"raw_body":     sr.RawBody,
+		"body_object":  toJQ(sr.BodyObject),
+		"body_array":   toJQ(sr.BodyArray),
+		"content_type": sr.ContentType,
+		"headers":      valuesToJQ(sr.Headers),
+		"anomaly":      sr.Anomaly.jqValue(),
+		"time_ms":      int(sr.TimeMS),
+		"size":         int(sr.Size),
+		"truncated":    sr.Truncated,
+		"body_hash":    sr.BodyHash,
+		"redirects":    redirectsToJQ(sr.Redirects),
+		"timing": map[string]any{
+			"dns_ms":     sr.Timing.DNS,
+			"connect_ms": sr.Timing.Connect,
+			"tls_ms":     sr.Timing.TLS,
+			"ttfb_ms":    sr.Timing.TTFB,
+			"total_ms":   sr.Timing.Total,
+			"reused":     sr.Timing.Reused,
+		},
+		"cookies":     cookiesToJQ(sr.Cookies),
+		"remote_addr": sr.RemoteAddr,
+		"proto":       sr.Proto,
+		"tls":         sr.TLS.jqValue(),
+	}
+}
+
+func (r *SimpleRequest) jqValue() map[string]any {
+	return map[string]any{
+		"path":        r.Path,
+		"query":       valuesToJQ(r.Query),
+		"method":      r.Method,
+		"url":         r.URL,
+		"host":        r.Host,
+		"headers":     valuesToJQ(r.Headers),
+		"body":        r.Body,
+		"list_params": stringsToJQ(r.ListParams),
+	}
+}
+
+func cookiesToJQ(cookies []Cookie) any {
+	if cookies == nil {
+		return nil
+	}
+
+	list := make([]any, len(cookies))
+	for i, c := range cookies {
+		list[i] = map[string]any{
+			"name":      c.Name,
+			"value":     c.Value,
+			"path":      c.Path,
+			"domain":    c.Domain,
+			"expires":   c.Expires,
+			"max_age":   c.MaxAge,
+			"secure":    c.Secure,
+			"http_only": c.HttpOnly,
+			"same_site": c.SameSite,
+		}
+	}
+	return list
+}
+
+func (info *TLSInfo) jqValue() any {
+	if info == nil {
+		return nil
+	}
+
+	return map[string]any{
+		"version":        info.Version,
+		"cipher_suite":   info.CipherSuite,
+		"server_name":    info.ServerName,
+		"alpn":           info.ALPN,
+		"peer_subject":   info.PeerSubject,
+		"peer_issuer":    info.PeerIssuer,
+		"peer_dns_names": stringsToJQ(info.PeerDNSNames),
+		"peer_not_after": info.PeerNotAfter,
+	}
+}
+
+// stringsToJQ converts a string slice, keeping nil as null like JSON does.
+func stringsToJQ(values []string) any {
+	if values == nil {
+		return nil
+	}
+
+	list := make([]any, len(values))
+	for i, v := range values {
+		list[i] = v
+	}
+	return list
+}
+
+func redirectsToJQ(redirects []Redirect) any {
+	if redirects == nil {
+		return nil
+	}
+
+	list := make([]any, len(redirects))
+	for i, r := range redirects {
+		list[i] = map[string]any{
+			"url":         r.URL,
+			"status":      r.Status,
+			"location":    r.Location,
+			"set_cookies": stringsToJQ(r.SetCookies),
+		}
+	}
+	return list
+}
+
+func (a *Anomaly) jqValue() any {
+	if a == nil {
+		return nil
+	}
+
+	reasons := make([]any, len(a.Reasons))
+	for i, reason := range a.Reasons {
+		reasons[i] = reason
+	}
+	headers := make([]any, len(a.Features.Headers))
+	for i, header := range a.Features.Headers {
+		headers[i] = header
+	}
+
+	return map[string]any{
+		"learning":  a.Learning,
+		"score":     a.Score,
+		"anomalous": a.Anomalous,
+		"reasons":   reasons,
+		"features": map[string]any{
+			"status":  a.Features.Status,
+			"length":  a.Features.Length,
+			"words":   a.Features.Words,
+			"lines":   a.Features.Lines,
+			"headers": headers,
+			"simhash": a.Features.SimHash,
+		},
+	}
+}
+
+func valuesToJQ(values map[string][]string) any {
+	if values == nil {
+		return nil
+	}
+
+	m := make(map[string]any, len(values))
+	for k, vs := range values {
+		if vs == nil {
+			m[k] = nil
+			continue
+		}
+		list := make([]any, len(vs))
+		for i, v := range vs {
+			list[i] = v
+		}
+		m[k] = list
+	}
+	return m
+}
+
+// toJQ normalizes values that didn't come straight out of json.Unmarshal,
+// such as bodies restored from a checkpoint or set in code.
+func toJQ(v any) any {
+	switch v := v.(type) {
+	case nil, bool, string, float64, int, map[string]any, []any:
+		return v
+	case url.Values:
+		return valuesToJQ(v)
+	case map[string][]string:
+		return valuesToJQ(v)
+	case []map[string]any:
+		list := make([]any, len(v))
+		for i, item := range v {
+			list[i] = item
+		}
+		return list
+	}
+
+	// anything else takes the slow path
+	jsonBytes, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	var normalized any
+	json.Unmarshal(jsonBytes, &normalized)
+	return normalized
+}
+
+// jqHeader implements header($name), the first value of a response header
+// looked up case-insensitively, or null.
+func jqHeader(v any, args []any) any {
+	values, err := headerValues(v, args, "header")
+	if err != nil {
+		return err
+	}
+	if len(values) == 0 {
+		return nil
+	}
+	return values[0]
+}
+
+// jqCookie implements cookie($name), the value of a cookie set by the
+// response, or null.
+func jqCookie(v any, args []any) any {
+	name, ok := args[0].(string)
+	if !ok {
+		return fmt.Errorf("cookie: name must be a string, got %T", args[0])
+	}
+
+	values, err := headerValues(v, []any{"Set-Cookie"}, "cookie")
+	if err != nil {
+		return err
+	}
+
+	header := http.Header{}
+	for _, value := range values {
+		header.Add("Set-Cookie", value.(string))
+	}
+	for _, cookie := range (&http.Response{Header: header}).Cookies() {
+		if cookie.Name == name {
+			return cookie.Value
+		}
+	}
+	return nil
+}
+
+func headerValues(v any, args []any, fn string) ([]any, error) {
+	name, ok := args[0].(string)
+	if !ok {
+		return nil, fmt.Errorf("%s: name must be a string, got %T", fn, args[0])
+	}
+
+	resp, ok := v.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("%s: input must be a response, got %T", fn, v)
+	}
+	headers, _ := resp["headers"].(map[string]any)
+
+	for key, values := range headers {
+		if strings.EqualFold(key, name) {
+			list, _ := values.([]any)
+			return list, nil
+		}
+	}
+	return nil, nil
+}
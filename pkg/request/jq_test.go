@@ -0,0 +1,165 @@
+package request
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func largeJSONBody(items int) []byte {
+	var b strings.Builder
+	b.WriteString(`{"data": {"items": [`)
+	for i := 0; i < items; i++ {
+		if i > 0 {
+			b.WriteString(",")
+		}
+		fmt.Fprintf(&b, `{"id": %d, "name": "item-%d", "tags": ["a", "b", "c"], "active": %v}`, i, i, i%2 == 0)
+	}
+	b.WriteString(`]}, "next": null}`)
+	return []byte(b.String())
+}
+
+func TestJQValueMatchesJSON(t *testing.T) {
+	sr := &SimpleResponse{
+		Request: SimpleRequest{
+			Path:       "/test",
+			Query:      url.Values{"page": {"1"}},
+			Method:     "POST",
+			URL:        "http://example.com/test?page=1",
+			Host:       "example.com",
+			Headers:    map[string][]string{"Content-Type": {"application/json"}},
+			Body:       `{"user": "admin"}`,
+			ListParams: []string{"admin"},
+		},
+		Status:      200,
+		ContentType: "application/json",
+		Headers:     map[string][]string{"X-Test": {"a", "b"}},
+		TimeMS:      12,
+		Anomaly:     &Anomaly{Score: 0.5, Reasons: []string{"status"}, Features: ResponseFeatures{Headers: []string{"x-test"}}},
+		Redirects:   []Redirect{{URL: "http://example.com/", Status: 302, Location: "/test", SetCookies: []string{"a=b"}}},
+		Cookies:     []Cookie{{Name: "session", Value: "abc", HttpOnly: true, SameSite: "Lax"}},
+		RemoteAddr:  "127.0.0.1:443",
+		Proto:       "HTTP/2.0",
+		TLS:         &TLSInfo{Version: "TLS 1.3", PeerSubject: "CN=example.com", PeerDNSNames: []string{"example.com"}},
+	}
+	body := largeJSONBody(3)
+	sr.RawBody = string(body)
+	sr.parseBody(FormatAuto, body)
+
+	jsonM, err := json.Marshal(sr)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	expected := map[string]any{}
+	json.Unmarshal(jsonM, &expected)
+
+	// normalize numbers the same way json does before comparing
+	gotBytes, _ := json.Marshal(sr.jqValue())
+	got := map[string]any{}
+	json.Unmarshal(gotBytes, &got)
+
+	if !reflect.DeepEqual(expected, got) {
+		t.Errorf("Expected jq value to match JSON encoding\nexpected: %v\ngot:      %v", expected, got)
+	}
+}
+
+func TestFromBytesInvalidCondition(t *testing.T) {
+	if _, err := FromBytes([]byte("stop_when:\n  - 'select(.status =='\n")); err == nil {
+		t.Error("Expected error for invalid jq expression")
+	}
+}
+
+func TestConditionVariablesAndFunctions(t *testing.T) {
+	sr := &SimpleResponse{
+		Status:     200,
+		BodyObject: map[string]any{"user": "alice"},
+		Headers: map[string][]string{
+			"Content-Type": {"application/json"},
+			"Set-Cookie":   {"session=abc123; Path=/", "theme=dark"},
+		},
+	}
+	c := &RequestContext{
+		Iteration:  4,
+		Page:       5,
+		AuthToken:  "secret",
+		ListParams: []string{"alice"},
+		Extra:      map[string]interface{}{"max": "3"},
+	}
+
+	tests := []struct {
+		expr     string
+		expected bool
+	}{
+		{`$page > ($extra.max | tonumber)`, true},
+		{`$iteration == 4`, true},
+		{`.body_object.user == $list[0]`, true},
+		{`$auth_token == "secret"`, true},
+		{`header("content-type") == "application/json"`, true},
+		{`header("x-missing") == null`, true},
+		{`cookie("session") == "abc123"`, true},
+		{`cookie("theme") == "light"`, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			code, err := compileJQ(tt.expr)
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if got := runCondition(code, newJQInput(c, sr), truthy); got != tt.expected {
+				t.Errorf("Expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func BenchmarkEvaluate(b *testing.B) {
+	for _, items := range []int{100, 10000} {
+		body := largeJSONBody(items)
+		b.Run(fmt.Sprintf("items=%d", items), func(b *testing.B) {
+			tr, err := FromBytes([]byte(`
+stop_when:
+  - 'select(.body_object.next == null and (.body_object.data.items | length) == 0) | .'
+  - 'select(.status >= 500) | .'
+`))
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			c := &RequestContext{Page: 1}
+			headers := http.Header{"Content-Type": {"application/json"}}
+
+			b.SetBytes(int64(len(body)))
+			b.ReportAllocs()
+			for b.Loop() {
+				tr.Evaluate(c, &SimpleResponse{Status: 200, Headers: headers, Body: body})
+			}
+		})
+	}
+}
+
+func BenchmarkJQValue(b *testing.B) {
+	body := largeJSONBody(10000)
+	sr := &SimpleResponse{RawBody: string(body)}
+	sr.parseBody(FormatAuto, body)
+
+	b.Run("direct", func(b *testing.B) {
+		b.SetBytes(int64(len(body)))
+		for b.Loop() {
+			sr.jqValue()
+		}
+	})
+
+	b.Run("json round trip", func(b *testing.B) {
+		b.SetBytes(int64(len(body)))
+		for b.Loop() {
+			jsonM, _ := json.Marshal(sr)
+			r := map[string]any{}
+			json.Unmarshal(jsonM, &r)
+		}
+	})
+}
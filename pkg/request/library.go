@@ -0,0 +1,116 @@
+package request
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Library is a catalogue of templates loaded from files and directories,
+// looked up by name.
+type Library struct {
+	templates map[string]*TemplateRequest
+}
+
+// LoadLibrary loads every template in paths. Directories are searched
+// recursively for .yaml and .yml files. Files and directories starting with
+// _ or . are skipped, so they can hold the bases and fragments templates
+// extend and include.
+func LoadLibrary(paths ...string) (*Library, error) {
+	l := &Library{}
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+
+		if !info.IsDir() {
+			if err := l.addFile(path); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		err = filepath.WalkDir(path, func(file string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if name := d.Name(); file != path && (strings.HasPrefix(name, "_") || strings.HasPrefix(name, ".")) {
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if d.IsDir() {
+				return nil
+			}
+			switch strings.ToLower(filepath.Ext(file)) {
+			case ".yaml", ".yml":
+				return l.addFile(file)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+func (l *Library) addFile(filename string) error {
+	templates, err := FromFileAll(filename)
+	if err != nil {
+		return err
+	}
+
+	for i, tr := range templates {
+		if tr.Name == "" {
+			if len(templates) > 1 {
+				return fmt.Errorf("%s: template %d has no name", filename, i+1)
+			}
+			tr.Name = strings.TrimSuffix(filepath.Base(filename), filepath.Ext(filename))
+		}
+		if err := l.Add(tr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Add adds a template to the library under its name.
+func (l *Library) Add(tr *TemplateRequest) error {
+	if tr.Name == "" {
+		return errors.New("template without a name")
+	}
+	if l.templates == nil {
+		l.templates = map[string]*TemplateRequest{}
+	}
+	if existing, ok := l.templates[tr.Name]; ok {
+		return fmt.Errorf("template %q is defined in both %s and %s", tr.Name, existing.File, tr.File)
+	}
+	l.templates[tr.Name] = tr
+	return nil
+}
+
+// Get returns the template named name.
+func (l *Library) Get(name string) (*TemplateRequest, error) {
+	tr, ok := l.templates[name]
+	if !ok {
+		return nil, fmt.Errorf("no template named %q", name)
+	}
+	return tr, nil
+}
+
+// Templates returns the templates of the library sorted by name.
+func (l *Library) Templates() []*TemplateRequest {
+	templates := make([]*TemplateRequest, 0, len(l.templates))
+	for _, tr := range l.templates {
+		templates = append(templates, tr)
+	}
+	slices.SortFunc(templates, func(a, b *TemplateRequest) int { return strings.Compare(a.Name, b.Name) })
+	return templates
+}
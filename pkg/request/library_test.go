@@ -0,0 +1,104 @@
+package request
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFromFileAll(t *testing.T) {
+	dir := writeTemplates(t, map[string]string{
+		"base.yaml": "method: GET\nurl: http://localhost/\n",
+		"api.yaml": `
+name: users
+extends: base.yaml
+url: http://localhost/users
+---
+name: groups
+description: Every group
+extends: base.yaml
+---
+`,
+	})
+
+	templates, err := FromFileAll(filepath.Join(dir, "api.yaml"))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(templates) != 2 {
+		t.Fatalf("Expected 2 templates, got %d", len(templates))
+	}
+	if templates[0].Name != "users" || templates[0].URL != "http://localhost/users" || templates[0].Method != "GET" {
+		t.Errorf("Expected users to extend the base, got %+v", templates[0])
+	}
+	if templates[1].Description != "Every group" || templates[1].URL != "http://localhost/" {
+		t.Errorf("Expected groups to extend the base, got %+v", templates[1])
+	}
+	if templates[1].File != filepath.Join(dir, "api.yaml") {
+		t.Errorf("Expected the file to be recorded, got %s", templates[1].File)
+	}
+
+	if _, err := FromFile(filepath.Join(dir, "api.yaml")); err == nil || !strings.Contains(err.Error(), "holds 2 templates") {
+		t.Errorf("Expected FromFile to refuse several templates, got %v", err)
+	}
+}
+
+func TestLoadLibrary(t *testing.T) {
+	dir := writeTemplates(t, map[string]string{
+		"_base/api.yaml":    "method: GET\nurl: http://localhost/\n",
+		".hidden/x.yaml":    "name: hidden\n",
+		"users/list.yaml":   "name: users\ndescription: List users\nextends: ../_base/api.yaml\n---\nname: user\nextends: ../_base/api.yaml\n",
+		"groups.yml":        "extends: _base/api.yaml\n",
+		"notes.txt":         "not a template",
+		"users/_draft.yaml": "name: draft\n",
+	})
+
+	library, err := LoadLibrary(dir)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	names := []string{}
+	for _, tr := range library.Templates() {
+		names = append(names, tr.Name)
+	}
+	if strings.Join(names, ",") != "groups,user,users" {
+		t.Errorf("Expected groups, user and users, got %v", names)
+	}
+
+	tr, err := library.Get("users")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if tr.Description != "List users" || tr.Method != "GET" {
+		t.Errorf("Expected the users template, got %+v", tr)
+	}
+
+	if _, err := library.Get("draft"); err == nil {
+		t.Error("Expected files starting with _ to be skipped")
+	}
+}
+
+func TestLoadLibraryErrors(t *testing.T) {
+	dir := writeTemplates(t, map[string]string{
+		"a.yaml":       "name: dup\n",
+		"b.yaml":       "name: dup\n",
+		"multi.yaml":   "name: one\n---\nurl: http://localhost/\n",
+		"single/x.yml": "url: http://localhost/\n",
+	})
+
+	if _, err := LoadLibrary(filepath.Join(dir, "a.yaml"), filepath.Join(dir, "b.yaml")); err == nil || !strings.Contains(err.Error(), `"dup"`) {
+		t.Errorf("Expected a duplicate name error, got %v", err)
+	}
+	if _, err := LoadLibrary(filepath.Join(dir, "multi.yaml")); err == nil || !strings.Contains(err.Error(), "template 2 has no name") {
+		t.Errorf("Expected an error for an unnamed template among several, got %v", err)
+	}
+
+	library, err := LoadLibrary(filepath.Join(dir, "single"))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if _, err := library.Get("x"); err != nil {
+		t.Errorf("Expected an unnamed template to be named after its file, got %v", err)
+	}
+}
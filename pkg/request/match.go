@@ -0,0 +1,277 @@
+package request
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/itchyny/gojq"
+	"gopkg.in/yaml.v3"
+)
+
+// Matcher decides which responses are reported. The same type is used for
+// the match section, where any (or all, depending on Mode) rule has to hit
+// for a response to be reported, and the filter section, where a hit drops
+// the response.
+type Matcher struct {
+	Status IntRanges `yaml:"status"`
+	Size   IntRanges `yaml:"size"`
+	Words  IntRanges `yaml:"words"`
+	Lines  IntRanges `yaml:"lines"`
+	Regex  []string  `yaml:"regex"`
+	JQ     []string  `yaml:"jq"`
+	// Time compares the response time, e.g. ">500ms" or "<1s".
+	Time string `yaml:"time"`
+	// Mode is "or" (default) or "and".
+	Mode string `yaml:"mode"`
+
+	compiled bool
+	regexps  []*regexp.Regexp
+	codes    []*gojq.Code
+	and      bool
+	// timeOp is '>' or '<', compared against timeLimit.
+	timeOp    byte
+	timeLimit time.Duration
+}
+
+// IntRanges is a list of numbers or inclusive ranges like "200-299".
+type IntRanges []IntRange
+
+type IntRange struct {
+	Min int
+	Max int
+}
+
+// ParseIntRanges parses values like "200", "200-299" or "200,302".
+func ParseIntRanges(values []string) (IntRanges, error) {
+	ranges := IntRanges{}
+	for _, value := range values {
+		for _, part := range strings.Split(value, ",") {
+			part = strings.TrimSpace(part)
+			if part == "" {
+				continue
+			}
+
+			r, err := parseIntRange(part)
+			if err != nil {
+				return nil, err
+			}
+			ranges = append(ranges, r)
+		}
+	}
+	return ranges, nil
+}
+
+func parseIntRange(s string) (IntRange, error) {
+	lo, hi, found := strings.Cut(s, "-")
+	min, err := strconv.Atoi(strings.TrimSpace(lo))
+	if err != nil {
+		return IntRange{}, fmt.Errorf("invalid number %q", s)
+	}
+	if !found {
+		return IntRange{Min: min, Max: min}, nil
+	}
+
+	max, err := strconv.Atoi(strings.TrimSpace(hi))
+	if err != nil {
+		return IntRange{}, fmt.Errorf("invalid range %q", s)
+	}
+	return IntRange{Min: min, Max: max}, nil
+}
+
+func (r *IntRanges) UnmarshalYAML(value *yaml.Node) error {
+	var values []string
+	if value.Kind == yaml.ScalarNode {
+		values = []string{value.Value}
+	} else if err := value.Decode(&values); err != nil {
+		return err
+	}
+
+	parsed, err := ParseIntRanges(values)
+	if err != nil {
+		return err
+	}
+	*r = parsed
+	return nil
+}
+
+func (r IntRanges) Contains(n int) bool {
+	for _, rng := range r {
+		if n >= rng.Min && n <= rng.Max {
+			return true
+		}
+	}
+	return false
+}
+
+// Compile compiles the regexes and jq expressions of the matcher and checks
+// its time rule and mode. Match compiles on first use and a matcher that does
+// not compile matches nothing, calling Compile up front surfaces the error.
+func (m *Matcher) Compile() error {
+	m.regexps = nil
+	for _, expr := range m.Regex {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return fmt.Errorf("compiling regex %q: %w", expr, err)
+		}
+		m.regexps = append(m.regexps, re)
+	}
+
+	var err error
+	if m.codes, err = compileJQAll(m.JQ); err != nil {
+		return err
+	}
+
+	if m.Time != "" {
+		if m.timeOp, m.timeLimit, err = parseTimeMatcher(m.Time); err != nil {
+			return err
+		}
+	}
+
+	switch strings.ToLower(m.Mode) {
+	case "", "or":
+		m.and = false
+	case "and":
+		m.and = true
+	default:
+		return fmt.Errorf("unknown matcher mode %q, expected or or and", m.Mode)
+	}
+
+	m.compiled = true
+	return nil
+}
+
+// parseTimeMatcher parses time rules like ">500ms" or "<1s".
+func parseTimeMatcher(s string) (byte, time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || (s[0] != '>' && s[0] != '<') {
+		return 0, 0, fmt.Errorf("time matcher %q must start with > or <", s)
+	}
+	d, err := time.ParseDuration(strings.TrimSpace(s[1:]))
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid time matcher %q: %w", s, err)
+	}
+	return s[0], d, nil
+}
+
+// IsEmpty reports whether the matcher has no rules.
+func (m *Matcher) IsEmpty() bool {
+	return m == nil || (len(m.Status) == 0 && len(m.Size) == 0 && len(m.Words) == 0 &&
+		len(m.Lines) == 0 && len(m.Regex) == 0 && len(m.JQ) == 0 && m.Time == "")
+}
+
+// Match reports whether sr hits the matcher's rules. c provides the
+// variables for jq rules and may be nil.
+func (m *Matcher) Match(c *RequestContext, sr *SimpleResponse) bool {
+	if !m.compiled {
+		if err := m.Compile(); err != nil {
+			return false
+		}
+	}
+
+	results := []bool{}
+
+	if len(m.Status) > 0 {
+		results = append(results, m.Status.Contains(sr.Status))
+	}
+	if len(m.Size) > 0 {
+		results = append(results, m.Size.Contains(sr.size()))
+	}
+	if len(m.Words) > 0 {
+		results = append(results, m.Words.Contains(countWords(sr.RawBody)))
+	}
+	if len(m.Lines) > 0 {
+		results = append(results, m.Lines.Contains(countLines(sr.RawBody)))
+	}
+	if len(m.Regex) > 0 {
+		results = append(results, m.matchRegex(sr))
+	}
+	if len(m.JQ) > 0 {
+		results = append(results, m.matchJQ(c, sr))
+	}
+	if m.Time != "" {
+		results = append(results, m.matchTime(sr))
+	}
+
+	if len(results) == 0 {
+		return false
+	}
+
+	for _, result := range results {
+		if m.and && !result {
+			return false
+		}
+		if !m.and && result {
+			return true
+		}
+	}
+	return m.and
+}
+
+func (m *Matcher) matchRegex(sr *SimpleResponse) bool {
+	// ffuf matches regexes against headers as well as the body
+	var raw strings.Builder
+	for name, values := range sr.Headers {
+		for _, value := range values {
+			fmt.Fprintf(&raw, "%s: %s\n", name, value)
+		}
+	}
+	raw.WriteString("\n")
+	raw.WriteString(sr.RawBody)
+
+	for _, re := range m.regexps {
+		if re.MatchString(raw.String()) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *Matcher) matchJQ(c *RequestContext, sr *SimpleResponse) bool {
+	in := newJQInput(c, sr)
+	for _, code := range m.codes {
+		if runCondition(code, in, truthy) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *Matcher) matchTime(sr *SimpleResponse) bool {
+	elapsed := time.Duration(sr.TimeMS) * time.Millisecond
+	if m.timeOp == '>' {
+		return elapsed > m.timeLimit
+	}
+	return elapsed < m.timeLimit
+}
+
+// Report reports whether sr passes the template's match and filter sections
+// and should be handed to the output. A body that stop_on_repeat ended the
+// run on is not reported again.
+func (tr *TemplateRequest) Report(c *RequestContext, sr *SimpleResponse) bool {
+	if sr.repeated {
+		return false
+	}
+	if err := tr.compiled(); err != nil {
+		tr.logger().Println(err)
+		return false
+	}
+
+	if !tr.Match.IsEmpty() && !tr.Match.Match(c, sr) {
+		return false
+	}
+	if !tr.Filter.IsEmpty() && tr.Filter.Match(c, sr) {
+		return false
+	}
+	return true
+}
+
+func countWords(body string) int {
+	return len(strings.Fields(body))
+}
+
+func countLines(body string) int {
+	return len(strings.Split(body, "\n"))
+}
@@ -0,0 +1,143 @@
+package request
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseIntRanges(t *testing.T) {
+	ranges, err := ParseIntRanges([]string{"200,302", "500-599"})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	for _, n := range []int{200, 302, 500, 550, 599} {
+		if !ranges.Contains(n) {
+			t.Errorf("Expected ranges to contain %d", n)
+		}
+	}
+	for _, n := range []int{201, 404, 600} {
+		if ranges.Contains(n) {
+			t.Errorf("Expected ranges not to contain %d", n)
+		}
+	}
+
+	if _, err := ParseIntRanges([]string{"abc"}); err == nil {
+		t.Error("Expected error for invalid range")
+	}
+}
+
+func TestMatcherYAML(t *testing.T) {
+	tr, err := FromBytes([]byte(`
+match:
+  status: [200, "300-399"]
+  regex: ["admin"]
+filter:
+  size: 1234
+`))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if !tr.Match.Status.Contains(302) || !tr.Filter.Size.Contains(1234) {
+		t.Errorf("Expected matcher ranges to be parsed, got %+v %+v", tr.Match, tr.Filter)
+	}
+}
+
+func TestMatcherMatch(t *testing.T) {
+	sr := &SimpleResponse{
+		Status:     200,
+		RawBody:    `{"user": "admin"}`,
+		BodyObject: map[string]any{"user": "admin"},
+		Headers:    map[string][]string{"X-Powered-By": {"php"}},
+		TimeMS:     600,
+	}
+
+	tests := []struct {
+		name     string
+		matcher  Matcher
+		expected bool
+	}{
+		{"status", Matcher{Status: IntRanges{{200, 200}}}, true},
+		{"status miss", Matcher{Status: IntRanges{{404, 404}}}, false},
+		{"size", Matcher{Size: IntRanges{{17, 17}}}, true},
+		{"words", Matcher{Words: IntRanges{{2, 2}}}, true},
+		{"lines", Matcher{Lines: IntRanges{{1, 1}}}, true},
+		{"regex body", Matcher{Regex: []string{"adm[i]n"}}, true},
+		{"regex header", Matcher{Regex: []string{"X-Powered-By: php"}}, true},
+		{"jq", Matcher{JQ: []string{`.body_object.user == "admin"`}}, true},
+		{"jq false", Matcher{JQ: []string{`.body_object.user == "bob"`}}, false},
+		{"time", Matcher{Time: ">500ms"}, true},
+		{"time miss", Matcher{Time: "<500ms"}, false},
+		{"or", Matcher{Status: IntRanges{{404, 404}}, Regex: []string{"admin"}}, true},
+		{"and", Matcher{Status: IntRanges{{404, 404}}, Regex: []string{"admin"}, Mode: "and"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.matcher.Match(nil, sr); got != tt.expected {
+				t.Errorf("Expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestRecurseReportsMatchesUntilListsExhausted(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/admin" {
+			fmt.Fprint(w, "welcome")
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	tr := &TemplateRequest{
+		Method: "GET",
+		URL:    server.URL + "/{{ index .ListParams 0 }}",
+		Lists:  [][]string{{"a", "admin", "b", "c"}},
+		Filter: &Matcher{Status: IntRanges{{404, 404}}},
+	}
+
+	reported := []string{}
+	tr.Recurse(&RequestContext{}, func(body []byte) {
+		reported = append(reported, string(body))
+	})
+
+	if len(reported) != 1 || reported[0] != "welcome" {
+		t.Errorf("Expected only the admin response, got %v", reported)
+	}
+	if tr.LastResponse.Request.Path != "/c" {
+		t.Errorf("Expected the run to reach the last list entry, got %s", tr.LastResponse.Request.Path)
+	}
+}
+
+func TestSendInvalidMatcher(t *testing.T) {
+	tr := &TemplateRequest{Method: "GET", URL: "http://localhost", Match: &Matcher{Regex: []string{"("}}}
+	if _, _, err := tr.Send(&RequestContext{}); err == nil {
+		t.Error("Expected an error for an invalid match regex")
+	}
+
+	m := &Matcher{JQ: []string{".status =="}}
+	if m.Match(nil, &SimpleResponse{}) {
+		t.Error("Expected an invalid matcher not to match")
+	}
+}
+
+func TestMatcherCompileInvalid(t *testing.T) {
+	for _, m := range []Matcher{{Time: "500ms"}, {Time: ">soon"}, {Mode: "xor"}} {
+		if err := m.Compile(); err == nil {
+			t.Errorf("Expected an error for %+v", m)
+		}
+	}
+
+	m := Matcher{Time: " > 1s ", Mode: "AND"}
+	if err := m.Compile(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !m.Match(nil, &SimpleResponse{TimeMS: 1500}) {
+		t.Error("Expected a 1.5s response to match >1s")
+	}
+}
@@ -0,0 +1,225 @@
+package request
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Middleware wraps the RoundTripper HTTP requests are sent through, to log,
+// change, record or answer them.
+type Middleware func(next http.RoundTripper) http.RoundTripper
+
+// MiddlewareFactory creates a middleware for a template from its options in
+// the template, which can be decoded into a struct with options.Decode. A
+// middleware given by name only has empty options.
+type MiddlewareFactory func(tr *TemplateRequest, options *yaml.Node) (Middleware, error)
+
+// MiddlewareConfig names a registered middleware in a template. It is either
+// just the name or a mapping with the name and the middleware's options:
+//
+//	middleware:
+//	  - log
+//	  - name: cache
+//	    dir: .requrse-cache
+//	    ttl: 1h
+type MiddlewareConfig struct {
+	Name    string
+	Options yaml.Node
+}
+
+func (m *MiddlewareConfig) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		m.Name = value.Value
+		return nil
+	}
+
+	var named struct {
+		Name string `yaml:"name"`
+	}
+	if err := value.Decode(&named); err != nil {
+		return err
+	}
+	if named.Name == "" {
+		return errors.New("middleware without a name")
+	}
+	m.Name = named.Name
+	m.Options = *value
+	return nil
+}
+
+var (
+	middlewareMu sync.RWMutex
+	middlewares  = map[string]MiddlewareFactory{
+		"log":     newLogMiddleware,
+		"dump":    newDumpMiddleware,
+		"cache":   newCacheMiddleware,
+		"headers": newHeadersMiddleware,
+		"fault":   newFaultMiddleware,
+	}
+)
+
+// RegisterMiddleware makes a middleware available to templates by name,
+// replacing any middleware already registered with it.
+func RegisterMiddleware(name string, factory MiddlewareFactory) {
+	middlewareMu.Lock()
+	defer middlewareMu.Unlock()
+	middlewares[strings.ToLower(name)] = factory
+}
+
+// Use adds middleware around the template's HTTP round trip, after the
+// middleware named in the template. It has to be called before the first
+// request is sent.
+func (tr *TemplateRequest) Use(mw ...Middleware) {
+	tr.middleware = append(tr.middleware, mw...)
+}
+
+// wrapTransport puts the template's middleware around rt, the first one
+// listed outermost.
+func (tr *TemplateRequest) wrapTransport(rt http.RoundTripper) (http.RoundTripper, error) {
+	chain := []Middleware{}
+	for _, config := range tr.Middleware {
+		middlewareMu.RLock()
+		factory, ok := middlewares[strings.ToLower(config.Name)]
+		middlewareMu.RUnlock()
+		if !ok {
+			return nil, fmt.Errorf("unknown middleware %q", config.Name)
+		}
+
+		mw, err := factory(tr, &config.Options)
+		if err != nil {
+			return nil, fmt.Errorf("middleware %s: %w", config.Name, err)
+		}
+		chain = append(chain, mw)
+	}
+	chain = append(chain, tr.middleware...)
+
+	return wrap(rt, chain), nil
+}
+
+func wrap(rt http.RoundTripper, chain []Middleware) http.RoundTripper {
+	for i := len(chain) - 1; i >= 0; i-- {
+		rt = chain[i](rt)
+	}
+	return rt
+}
+
+// decodeOptions decodes the options of a middleware into v, leaving v as it
+// is when there are none.
+func decodeOptions(options *yaml.Node, v any) error {
+	if options == nil || options.Kind == 0 {
+		return nil
+	}
+	return options.Decode(v)
+}
+
+// roundTripperFunc is an http.RoundTripper made of a function.
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// newLogMiddleware logs every request with its status and duration.
+func newLogMiddleware(tr *TemplateRequest, options *yaml.Node) (Middleware, error) {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			start := time.Now()
+			resp, err := next.RoundTrip(req)
+			if err != nil {
+				tr.logger().Printf("%s %s: %v", req.Method, req.URL, err)
+				return nil, err
+			}
+			tr.logger().Printf("%s %s: %d in %s", req.Method, req.URL, resp.StatusCode, time.Since(start).Round(time.Millisecond))
+			return resp, nil
+		})
+	}, nil
+}
+
+// newHeadersMiddleware sets headers on every request, including the
+// requests of redirects, which templates can not reach.
+//
+//	middleware:
+//	  - name: headers
+//	    set:
+//	      X-Debug: "1"
+func newHeadersMiddleware(tr *TemplateRequest, options *yaml.Node) (Middleware, error) {
+	var opts struct {
+		Set map[string]string `yaml:"set"`
+	}
+	if err := decodeOptions(options, &opts); err != nil {
+		return nil, err
+	}
+	headers := opts.Set
+
+	return func(next http.RoundTripper) http.RoundTripper {
+		return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			req = req.Clone(req.Context())
+			for name, value := range headers {
+				req.Header.Set(name, value)
+			}
+			return next.RoundTrip(req)
+		})
+	}, nil
+}
+
+// faultOptions configure the fault middleware. Rates are between 0 and 1.
+type faultOptions struct {
+	// Delay is added before every request.
+	Delay Duration `yaml:"delay"`
+	// ErrorRate is the share of requests that fail without being sent.
+	ErrorRate float64 `yaml:"error_rate"`
+	// StatusRate is the share of requests answered with Status without
+	// being sent.
+	StatusRate float64 `yaml:"status_rate"`
+	Status     int     `yaml:"status"`
+}
+
+// ErrInjectedFault is the error the fault middleware fails requests with.
+var ErrInjectedFault = errors.New("injected fault")
+
+// newFaultMiddleware delays and fails requests to test how templates, retries
+// and hooks cope with a flaky server.
+func newFaultMiddleware(tr *TemplateRequest, options *yaml.Node) (Middleware, error) {
+	opts := faultOptions{Status: http.StatusServiceUnavailable}
+	if err := decodeOptions(options, &opts); err != nil {
+		return nil, err
+	}
+
+	return func(next http.RoundTripper) http.RoundTripper {
+		return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			if opts.Delay > 0 {
+				timer := time.NewTimer(time.Duration(opts.Delay))
+				select {
+				case <-timer.C:
+				case <-req.Context().Done():
+					timer.Stop()
+					return nil, req.Context().Err()
+				}
+			}
+
+			if opts.ErrorRate > 0 && rand.Float64() < opts.ErrorRate {
+				return nil, ErrInjectedFault
+			}
+			if opts.StatusRate > 0 && rand.Float64() < opts.StatusRate {
+				return &http.Response{
+					Status:     fmt.Sprintf("%d %s", opts.Status, http.StatusText(opts.Status)),
+					StatusCode: opts.Status,
+					Proto:      "HTTP/1.1",
+					ProtoMajor: 1,
+					ProtoMinor: 1,
+					Header:     http.Header{},
+					Body:       http.NoBody,
+					Request:    req,
+				}, nil
+			}
+			return next.RoundTrip(req)
+		})
+	}, nil
+}
@@ -0,0 +1,162 @@
+package request
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestMiddlewareConfig(t *testing.T) {
+	tr, err := FromBytes([]byte(`
+url: http://localhost
+middleware:
+  - log
+  - name: headers
+    set:
+      X-Debug: "1"
+`))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if len(tr.Middleware) != 2 {
+		t.Fatalf("Expected 2 middleware, got %d", len(tr.Middleware))
+	}
+	if tr.Middleware[0].Name != "log" || tr.Middleware[1].Name != "headers" {
+		t.Errorf("Expected log and headers, got %s and %s", tr.Middleware[0].Name, tr.Middleware[1].Name)
+	}
+
+	var opts struct {
+		Set map[string]string `yaml:"set"`
+	}
+	if err := tr.Middleware[1].Options.Decode(&opts); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if opts.Set["X-Debug"] != "1" {
+		t.Errorf("Expected the headers options, got %v", opts.Set)
+	}
+
+	if _, err := FromBytes([]byte("middleware:\n  - set: {}\n")); err == nil {
+		t.Error("Expected error for middleware without a name")
+	}
+}
+
+// tagMiddleware appends name to the X-Chain header of requests.
+func tagMiddleware(name string) Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			req.Header.Add("X-Chain", name)
+			return next.RoundTrip(req)
+		})
+	}
+}
+
+func TestMiddlewareChain(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(strings.Join(r.Header.Values("X-Chain"), ",") + " " + r.Header.Get("X-Debug")))
+	}))
+	defer server.Close()
+
+	RegisterMiddleware("tag", func(tr *TemplateRequest, options *yaml.Node) (Middleware, error) {
+		var opts struct {
+			Value string `yaml:"value"`
+		}
+		if err := options.Decode(&opts); err != nil {
+			return nil, err
+		}
+		return tagMiddleware(opts.Value), nil
+	})
+
+	tr, err := FromBytes([]byte(`
+url: ` + server.URL + `
+method: GET
+max_iterations: 1
+middleware:
+  - name: tag
+    value: template
+  - name: headers
+    set:
+      X-Debug: "1"
+`))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	tr.Use(tagMiddleware("use"))
+
+	runner := NewRunner(WithMiddleware(tagMiddleware("runner")))
+	bodies := []string{}
+	for res := range runner.All(context.Background(), tr, nil) {
+		if res.Err != nil {
+			t.Fatalf("Expected no error, got %v", res.Err)
+		}
+		bodies = append(bodies, string(res.Body))
+	}
+
+	if len(bodies) != 1 || bodies[0] != "runner,template,use 1" {
+		t.Errorf("Expected runner, template and Use middleware in that order, got %v", bodies)
+	}
+
+	// without the runner only the template's own middleware is left
+	tr.MaxIterations = 1
+	bodies = []string{}
+	tr.Recurse(&RequestContext{}, func(body []byte) { bodies = append(bodies, string(body)) })
+	if len(bodies) != 1 || bodies[0] != "template,use 1" {
+		t.Errorf("Expected the template's middleware, got %v", bodies)
+	}
+}
+
+func TestUnknownMiddleware(t *testing.T) {
+	tr := &TemplateRequest{
+		URL:        "http://localhost",
+		Middleware: []MiddlewareConfig{{Name: "nope"}},
+	}
+
+	if _, _, err := tr.Send(&RequestContext{}); err == nil || !strings.Contains(err.Error(), "unknown middleware") {
+		t.Errorf("Expected unknown middleware error, got %v", err)
+	}
+}
+
+func TestFaultMiddleware(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("Expected no request to reach the server")
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name    string
+		options string
+		status  int
+		err     error
+	}{
+		{"error", "error_rate: 1", 0, ErrInjectedFault},
+		{"status", "status_rate: 1\nstatus: 429", 429, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var options yaml.Node
+			if err := yaml.Unmarshal([]byte(test.options), &options); err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+
+			mw, err := newFaultMiddleware(&TemplateRequest{}, options.Content[0])
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+
+			req, _ := http.NewRequest("GET", server.URL, nil)
+			resp, err := mw(http.DefaultTransport).RoundTrip(req)
+			if !errors.Is(err, test.err) {
+				t.Fatalf("Expected %v, got %v", test.err, err)
+			}
+			if test.status != 0 && (resp == nil || resp.StatusCode != test.status) {
+				t.Errorf("Expected status %d, got %v", test.status, resp)
+			}
+		})
+	}
+}
@@ -0,0 +1,228 @@
+package request
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/url"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Body formats understood by parseBody. FormatAuto picks one from the
+// response Content-Type and falls back to JSON.
+const (
+	FormatAuto = "auto"
+	FormatJSON = "json"
+	FormatXML  = "xml"
+	FormatYAML = "yaml"
+	FormatForm = "form"
+	FormatHTML = "html"
+)
+
+// bodyFormat maps a Content-Type to one of the body formats.
+func bodyFormat(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = strings.ToLower(strings.TrimSpace(contentType))
+	}
+
+	switch {
+	case mediaType == "application/x-www-form-urlencoded":
+		return FormatForm
+	case mediaType == "text/html" || mediaType == "application/xhtml+xml":
+		return FormatHTML
+	case strings.HasSuffix(mediaType, "/xml") || strings.HasSuffix(mediaType, "+xml"):
+		return FormatXML
+	case strings.HasSuffix(mediaType, "/yaml") || strings.HasSuffix(mediaType, "/x-yaml") || strings.HasSuffix(mediaType, "+yaml"):
+		return FormatYAML
+	}
+	return FormatJSON
+}
+
+// checkBodyFormat rejects a body_format that is not one of the Format
+// constants.
+func checkBodyFormat(format string) error {
+	switch format {
+	case "", FormatAuto, FormatJSON, FormatXML, FormatYAML, FormatForm, FormatHTML:
+		return nil
+	}
+	return fmt.Errorf("unknown body_format %q, expected auto, json, xml, yaml, form or html", format)
+}
+
+// parseBody fills BodyObject and BodyArray from the body. format is one of
+// the Format constants; FormatAuto decides by ContentType. Bodies that don't
+// parse leave both empty, HTML is left to the css and xpath jq functions.
+func (sr *SimpleResponse) parseBody(format string, body []byte) {
+	sr.BodyObject = map[string]any{}
+	sr.BodyArray = []any{}
+	sr.html = &htmlDoc{}
+
+	if format == "" || format == FormatAuto {
+		format = bodyFormat(sr.ContentType)
+	}
+
+	var parsed any
+	var err error
+	switch format {
+	case FormatXML:
+		parsed, err = parseXML(body)
+	case FormatYAML:
+		parsed, err = parseYAML(body)
+	case FormatForm:
+		parsed, err = parseForm(body)
+	case FormatHTML:
+		return
+	default:
+		err = json.Unmarshal(body, &parsed)
+	}
+	if err != nil {
+		return
+	}
+	sr.body = parsed
+
+	switch v := parsed.(type) {
+	case map[string]any:
+		sr.BodyObject = v
+	case []any:
+		sr.BodyArray = v
+	}
+}
+
+// bodyValue is the parsed body whatever its type, or the raw body when it
+// didn't parse.
+func (sr *SimpleResponse) bodyValue() any {
+	if sr.body != nil {
+		return sr.body
+	}
+	return sr.RawBody
+}
+
+func parseForm(body []byte) (any, error) {
+	values, err := url.ParseQuery(strings.TrimSpace(string(body)))
+	if err != nil {
+		return nil, err
+	}
+	return valuesToJQ(values), nil
+}
+
+func parseYAML(body []byte) (any, error) {
+	var parsed any
+	if err := yaml.Unmarshal(body, &parsed); err != nil {
+		return nil, err
+	}
+	return normalizeYAML(parsed), nil
+}
+
+// normalizeYAML turns what yaml.v3 decodes into values jq understands: maps
+// with non-string keys get their keys stringified.
+func normalizeYAML(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, item := range v {
+			v[k] = normalizeYAML(item)
+		}
+		return v
+	case map[any]any:
+		m := make(map[string]any, len(v))
+		for k, item := range v {
+			m[fmt.Sprint(k)] = normalizeYAML(item)
+		}
+		return m
+	case []any:
+		for i, item := range v {
+			v[i] = normalizeYAML(item)
+		}
+		return v
+	case int64:
+		return int(v)
+	case uint64:
+		return float64(v)
+	case float32:
+		return float64(v)
+	case nil, bool, string, int, float64:
+		return v
+	}
+	return fmt.Sprint(v)
+}
+
+// parseXML converts an XML document into a map keyed by the root element.
+// Attributes become "@name" keys, text next to child elements "#text", and
+// repeated child elements arrays. Elements with only text become strings.
+//
+//	<user id="1"><name>alice</name><role>a</role><role>b</role></user>
+//
+// becomes
+//
+//	{"user": {"@id": "1", "name": "alice", "role": ["a", "b"]}}
+func parseXML(body []byte) (any, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(body))
+	decoder.Strict = false
+
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil, errors.New("no root element")
+			}
+			return nil, err
+		}
+
+		if start, ok := token.(xml.StartElement); ok {
+			root, err := parseXMLElement(decoder, start)
+			if err != nil {
+				return nil, err
+			}
+			return map[string]any{start.Name.Local: root}, nil
+		}
+	}
+}
+
+func parseXMLElement(decoder *xml.Decoder, start xml.StartElement) (any, error) {
+	element := map[string]any{}
+	for _, attr := range start.Attr {
+		element["@"+attr.Name.Local] = attr.Value
+	}
+
+	var text strings.Builder
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			child, err := parseXMLElement(decoder, t)
+			if err != nil {
+				return nil, err
+			}
+
+			name := t.Name.Local
+			switch existing := element[name].(type) {
+			case nil:
+				element[name] = child
+			case []any:
+				element[name] = append(existing, child)
+			default:
+				element[name] = []any{existing, child}
+			}
+		case xml.CharData:
+			text.Write(t)
+		case xml.EndElement:
+			trimmed := strings.TrimSpace(text.String())
+			if len(element) == 0 {
+				return trimmed, nil
+			}
+			if trimmed != "" {
+				element["#text"] = trimmed
+			}
+			return element, nil
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package request
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBodyFormat(t *testing.T) {
+	tests := map[string]string{
+		"application/json; charset=utf-8":   FormatJSON,
+		"":                                  FormatJSON,
+		"text/xml":                          FormatXML,
+		"application/soap+xml":              FormatXML,
+		"application/x-yaml":                FormatYAML,
+		"application/x-www-form-urlencoded": FormatForm,
+		"text/html; charset=iso-8859-1":     FormatHTML,
+	}
+
+	for contentType, expected := range tests {
+		if got := bodyFormat(contentType); got != expected {
+			t.Errorf("Expected %s for %q, got %s", expected, contentType, got)
+		}
+	}
+}
+
+func TestParseBodyArray(t *testing.T) {
+	sr := &SimpleResponse{}
+	sr.parseBody(FormatAuto, []byte(`[{"id": 1}, {"id": 2}]`))
+
+	if list, ok := sr.BodyArray.([]any); !ok || len(list) != 2 {
+		t.Errorf("Expected body array with 2 items, got %v", sr.BodyArray)
+	}
+	if obj, ok := sr.BodyObject.(map[string]any); !ok || len(obj) != 0 {
+		t.Errorf("Expected empty body object, got %v", sr.BodyObject)
+	}
+}
+
+func TestParseBodyFormats(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		format      string
+		body        string
+		expected    map[string]any
+	}{
+		{
+			name:        "xml",
+			contentType: "text/xml",
+			body: `<?xml version="1.0"?>
+<user id="1"><name>alice</name><role>a</role><role>b</role><note lang="en">hi</note></user>`,
+			expected: map[string]any{"user": map[string]any{
+				"@id":  "1",
+				"name": "alice",
+				"role": []any{"a", "b"},
+				"note": map[string]any{"@lang": "en", "#text": "hi"},
+			}},
+		},
+		{
+			name:        "yaml",
+			contentType: "application/yaml",
+			body:        "user: alice\nroles: [a, b]\nids:\n  1: one\n",
+			expected: map[string]any{
+				"user":  "alice",
+				"roles": []any{"a", "b"},
+				"ids":   map[string]any{"1": "one"},
+			},
+		},
+		{
+			name:        "form",
+			contentType: "application/x-www-form-urlencoded",
+			body:        "user=alice&role=a&role=b",
+			expected:    map[string]any{"user": []any{"alice"}, "role": []any{"a", "b"}},
+		},
+		{
+			name:        "format override",
+			contentType: "text/plain",
+			format:      FormatForm,
+			body:        "token=abc",
+			expected:    map[string]any{"token": []any{"abc"}},
+		},
+		{
+			name:        "html",
+			contentType: "text/html",
+			body:        "<html><body>{}</body></html>",
+			expected:    map[string]any{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sr := &SimpleResponse{ContentType: tt.contentType}
+			sr.parseBody(tt.format, []byte(tt.body))
+
+			if !reflect.DeepEqual(sr.BodyObject, tt.expected) {
+				t.Errorf("Expected %v, got %v", tt.expected, sr.BodyObject)
+			}
+		})
+	}
+}
+
+func TestHTMLFunctions(t *testing.T) {
+	sr := &SimpleResponse{
+		ContentType: "text/html",
+		RawBody: `<html><head><title> Admin Panel </title></head><body>
+<a class="nav" href="/users">Users</a><a class="nav" href="/logout">Logout</a>
+<input name="csrf" value="tok123"></body></html>`,
+	}
+	c := &RequestContext{}
+
+	tests := []struct {
+		expr     string
+		expected bool
+	}{
+		{`css("title")[0] == "Admin Panel"`, true},
+		{`css("a.nav") | length == 2`, true},
+		{`css_attr("a.nav"; "href") == ["/users", "/logout"]`, true},
+		{`xpath("//input[@name='csrf']/@value")[0] == "tok123"`, true},
+		{`.raw_body | css("title")[0] == "Admin Panel"`, true},
+		{`css("h1") | length > 0`, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			code, err := compileJQ(tt.expr)
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if got := runCondition(code, newJQInput(c, sr), truthy); got != tt.expected {
+				t.Errorf("Expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestHTMLDocumentCachedPerResponse(t *testing.T) {
+	code, err := compileJQ(`css("title")[0]`)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	title := func(sr *SimpleResponse) any {
+		v, _ := code.Run(sr.jqValue(), sr.jqVariables(nil)...).Next()
+		return v
+	}
+
+	first := &SimpleResponse{RawBody: "<title>one</title>"}
+	first.parseBody(FormatHTML, []byte(first.RawBody))
+	second := &SimpleResponse{RawBody: "<title>two</title>"}
+	second.parseBody(FormatHTML, []byte(second.RawBody))
+
+	if title(first) != "one" || title(second) != "two" {
+		t.Fatal("Expected each response to query its own body")
+	}
+	doc := first.html.doc
+	if doc == nil || first.html.body != first.RawBody {
+		t.Fatal("Expected the parsed document cached on the response")
+	}
+	if title(first) != "one" || first.html.doc != doc {
+		t.Error("Expected the cached document to be reused")
+	}
+}
+
+func TestUnknownBodyFormat(t *testing.T) {
+	if _, err := FromBytes([]byte("method: GET\nurl: http://localhost\nbody_format: xlm\n")); err == nil {
+		t.Error("Expected an error for an unknown body_format")
+	}
+	if _, err := FromBytes([]byte("method: GET\nurl: http://localhost\nbody_format: xml\n")); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+}
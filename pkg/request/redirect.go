@@ -0,0 +1,77 @@
+package request
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+// DefaultMaxRedirects matches net/http's limit.
+const DefaultMaxRedirects = 10
+
+// Redirects controls which redirects HTTP requests follow. Without the
+// section every redirect is followed up to DefaultMaxRedirects, like before.
+//
+//	redirects:
+//	  follow: true
+//	  max: 3
+//	  same_host: true
+//
+// A redirect that is not followed, because following is off, the limit is
+// reached or it leaves the host, is returned as the response itself.
+type Redirects struct {
+	// Follow is on unless set to false.
+	Follow *bool `yaml:"follow"`
+	// Max is the number of redirects followed, DefaultMaxRedirects when zero.
+	Max int `yaml:"max"`
+	// SameHost only follows redirects to the host of the original request.
+	SameHost bool `yaml:"same_host"`
+}
+
+// Redirect is one followed hop of a redirect chain.
+type Redirect struct {
+	URL        string   `json:"url"`
+	Status     int      `json:"status"`
+	Location   string   `json:"location"`
+	SetCookies []string `json:"set_cookies"`
+}
+
+type redirectChainKey struct{}
+
+// withRedirectChain returns a context that collects the redirects followed
+// by the request it is used for into chain.
+func withRedirectChain(ctx context.Context, chain *[]Redirect) context.Context {
+	return context.WithValue(ctx, redirectChainKey{}, chain)
+}
+
+// checkRedirect is the template's http.Client CheckRedirect. via holds the
+// requests made so far, req.Response is the redirect being followed.
+func (tr *TemplateRequest) checkRedirect(req *http.Request, via []*http.Request) error {
+	policy := tr.Redirects
+	if policy.Follow != nil && !*policy.Follow {
+		return http.ErrUseLastResponse
+	}
+
+	max := policy.Max
+	if max <= 0 {
+		max = DefaultMaxRedirects
+	}
+	if len(via) > max {
+		return http.ErrUseLastResponse
+	}
+
+	if policy.SameHost && !strings.EqualFold(req.URL.Host, via[0].URL.Host) {
+		return http.ErrUseLastResponse
+	}
+
+	if chain, ok := req.Context().Value(redirectChainKey{}).(*[]Redirect); ok && req.Response != nil {
+		resp := req.Response
+		*chain = append(*chain, Redirect{
+			URL:        resp.Request.URL.String(),
+			Status:     resp.StatusCode,
+			Location:   resp.Header.Get("Location"),
+			SetCookies: resp.Header.Values("Set-Cookie"),
+		})
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package request
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func redirectServer() *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "abc"})
+		http.Redirect(w, r, "/step", http.StatusFound)
+	})
+	mux.HandleFunc("/step", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/home", http.StatusMovedPermanently)
+	})
+	mux.HandleFunc("/away", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "http://other.invalid/", http.StatusFound)
+	})
+	mux.HandleFunc("/home", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"home": true}`))
+	})
+	return httptest.NewServer(mux)
+}
+
+func TestRedirectChain(t *testing.T) {
+	server := redirectServer()
+	defer server.Close()
+
+	tr := &TemplateRequest{
+		Method:   "GET",
+		URL:      server.URL + "/login",
+		StopWhen: []string{`select(.redirects[0].set_cookies[0] | startswith("session=")) | .`},
+	}
+	_, shouldContinue, err := tr.Send(&RequestContext{})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	sr := tr.LastResponse
+	if sr.Status != 200 || sr.Request.Path != "/home" {
+		t.Errorf("Expected to end up at /home, got %d %s", sr.Status, sr.Request.Path)
+	}
+	if len(sr.Redirects) != 2 {
+		t.Fatalf("Expected two redirects, got %v", sr.Redirects)
+	}
+	if sr.Redirects[0].Status != 302 || sr.Redirects[0].Location != "/step" || sr.Redirects[1].Status != 301 {
+		t.Errorf("Expected 302 to /step then 301, got %+v", sr.Redirects)
+	}
+	if shouldContinue {
+		t.Error("Expected stop_when to see the redirect chain")
+	}
+}
+
+func TestRedirectPolicy(t *testing.T) {
+	server := redirectServer()
+	defer server.Close()
+
+	off := false
+	tests := []struct {
+		name      string
+		path      string
+		redirects Redirects
+		status    int
+		hops      int
+	}{
+		{"disabled", "/login", Redirects{Follow: &off}, 302, 0},
+		{"limited", "/login", Redirects{Max: 1}, 301, 1},
+		{"same host", "/away", Redirects{SameHost: true}, 302, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tr := &TemplateRequest{Method: "GET", URL: server.URL + test.path, Redirects: test.redirects}
+			if _, _, err := tr.Send(&RequestContext{}); err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if tr.LastResponse.Status != test.status || len(tr.LastResponse.Redirects) != test.hops {
+				t.Errorf("Expected status %d after %d hops, got %d after %d", test.status, test.hops, tr.LastResponse.Status, len(tr.LastResponse.Redirects))
+			}
+		})
+	}
+}
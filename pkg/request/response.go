@@ -0,0 +1,157 @@
+package request
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Cookie is a parsed Set-Cookie header of a response.
+type Cookie struct {
+	Name     string `json:"name"`
+	Value    string `json:"value"`
+	Path     string `json:"path"`
+	Domain   string `json:"domain"`
+	Expires  string `json:"expires"`
+	MaxAge   int    `json:"max_age"`
+	Secure   bool   `json:"secure"`
+	HttpOnly bool   `json:"http_only"`
+	SameSite string `json:"same_site"`
+}
+
+// TLSInfo describes the TLS connection a response came over.
+type TLSInfo struct {
+	Version     string `json:"version"`
+	CipherSuite string `json:"cipher_suite"`
+	ServerName  string `json:"server_name"`
+	// ALPN is the protocol negotiated with ALPN, e.g. "h2".
+	ALPN string `json:"alpn"`
+	// The Peer fields describe the leaf certificate of the server.
+	PeerSubject  string   `json:"peer_subject"`
+	PeerIssuer   string   `json:"peer_issuer"`
+	PeerDNSNames []string `json:"peer_dns_names"`
+	PeerNotAfter string   `json:"peer_not_after"`
+}
+
+// Redacted replaces credentials in a redacted request echo.
+const Redacted = "[REDACTED]"
+
+// redactedHeaders are replaced in a redacted request echo whatever their
+// value.
+var redactedHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie"}
+
+// echo fills in the rendered request and the list values that produced it.
+// Path and Query are left to the transport, they come from the request that
+// got the response, after redirects.
+func (r *SimpleRequest) echo(rendered *RenderedRequest, c *RequestContext) {
+	if r.Method == "" {
+		r.Method = rendered.Method
+	}
+	r.URL = rendered.URL.String()
+	r.Host = rendered.URL.Host
+	r.Headers = rendered.Header
+	r.Body = string(rendered.Body)
+	if c != nil && c.ListParams != nil {
+		r.ListParams = append([]string{}, c.ListParams...)
+	}
+}
+
+// Redact returns a copy of r for writing to disk, with credential headers
+// and authToken, wherever it appears, replaced by Redacted. Conditions and
+// templates see the echo as it was sent.
+func (r SimpleRequest) Redact(authToken string) SimpleRequest {
+	redact := func(s string) string { return s }
+	if authToken != "" {
+		redact = func(s string) string { return strings.ReplaceAll(s, authToken, Redacted) }
+	}
+
+	r.URL = redact(r.URL)
+	r.Path = redact(r.Path)
+	if r.Query != nil {
+		query := make(url.Values, len(r.Query))
+		for name, values := range r.Query {
+			for _, value := range values {
+				query[name] = append(query[name], redact(value))
+			}
+		}
+		r.Query = query
+	}
+
+	if r.Headers != nil {
+		headers := make(map[string][]string, len(r.Headers))
+		for name, values := range r.Headers {
+			if slices.ContainsFunc(redactedHeaders, func(h string) bool { return strings.EqualFold(h, name) }) {
+				headers[name] = []string{Redacted}
+				continue
+			}
+			for _, value := range values {
+				headers[name] = append(headers[name], redact(value))
+			}
+		}
+		r.Headers = headers
+	}
+
+	r.Body = redact(r.Body)
+	return r
+}
+
+func newCookies(header http.Header) []Cookie {
+	setCookies := (&http.Response{Header: header}).Cookies()
+	if len(setCookies) == 0 {
+		return nil
+	}
+
+	cookies := make([]Cookie, len(setCookies))
+	for i, cookie := range setCookies {
+		cookies[i] = Cookie{
+			Name:     cookie.Name,
+			Value:    cookie.Value,
+			Path:     cookie.Path,
+			Domain:   cookie.Domain,
+			MaxAge:   cookie.MaxAge,
+			Secure:   cookie.Secure,
+			HttpOnly: cookie.HttpOnly,
+			SameSite: sameSiteName(cookie.SameSite),
+		}
+		if !cookie.Expires.IsZero() {
+			cookies[i].Expires = cookie.Expires.UTC().Format(time.RFC3339)
+		}
+	}
+	return cookies
+}
+
+func sameSiteName(mode http.SameSite) string {
+	switch mode {
+	case http.SameSiteLaxMode:
+		return "Lax"
+	case http.SameSiteStrictMode:
+		return "Strict"
+	case http.SameSiteNoneMode:
+		return "None"
+	}
+	return ""
+}
+
+func newTLSInfo(state *tls.ConnectionState) *TLSInfo {
+	if state == nil {
+		return nil
+	}
+
+	info := &TLSInfo{
+		Version:     tls.VersionName(state.Version),
+		CipherSuite: tls.CipherSuiteName(state.CipherSuite),
+		ServerName:  state.ServerName,
+		ALPN:        state.NegotiatedProtocol,
+	}
+	if len(state.PeerCertificates) > 0 {
+		leaf := state.PeerCertificates[0]
+		info.PeerSubject = leaf.Subject.String()
+		info.PeerIssuer = leaf.Issuer.String()
+		info.PeerDNSNames = leaf.DNSNames
+		info.PeerNotAfter = leaf.NotAfter.UTC().Format(time.RFC3339)
+	}
+	return info
+}
@@ -0,0 +1,126 @@
+package request
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestResponseDetails(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "abc", Path: "/", HttpOnly: true, SameSite: http.SameSiteStrictMode})
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	tr := &TemplateRequest{
+		Method:   "POST",
+		URL:      server.URL + "/login?user={{index .ListParams 0}}",
+		Headers:  map[string]string{"X-User": "{{index .ListParams 0}}"},
+		Body:     `{"user": "{{index .ListParams 0}}"}`,
+		StopWhen: []string{`select(.request.list_params[0] == "admin" and .cookies[0].http_only) | .`},
+	}
+	tr.client = server.Client()
+
+	_, shouldContinue, err := tr.Send(&RequestContext{ListParams: []string{"admin"}})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if shouldContinue {
+		t.Error("Expected stop_when to see the request echo and cookies")
+	}
+
+	sr := tr.LastResponse
+	req := sr.Request
+	if req.Method != "POST" || req.Host != server.Listener.Addr().String() || req.Body != `{"user": "admin"}` {
+		t.Errorf("Expected the rendered request to be echoed, got %+v", req)
+	}
+	if req.Path != "/login" || req.Query.Get("user") != "admin" || req.URL != server.URL+"/login?user=admin" {
+		t.Errorf("Expected the request URL to be echoed, got %s %s %v", req.URL, req.Path, req.Query)
+	}
+	if len(req.Headers["X-User"]) != 1 || req.Headers["X-User"][0] != "admin" {
+		t.Errorf("Expected rendered headers, got %v", req.Headers)
+	}
+	if len(req.ListParams) != 1 || req.ListParams[0] != "admin" {
+		t.Errorf("Expected list params, got %v", req.ListParams)
+	}
+
+	if len(sr.Cookies) != 1 || sr.Cookies[0].Name != "session" || sr.Cookies[0].SameSite != "Strict" || !sr.Cookies[0].HttpOnly {
+		t.Errorf("Expected the session cookie to be parsed, got %+v", sr.Cookies)
+	}
+	if sr.RemoteAddr != server.Listener.Addr().String() {
+		t.Errorf("Expected remote address %s, got %s", server.Listener.Addr(), sr.RemoteAddr)
+	}
+	if sr.Proto != "HTTP/1.1" {
+		t.Errorf("Expected HTTP/1.1, got %s", sr.Proto)
+	}
+	if sr.TLS == nil || sr.TLS.Version == "" || sr.TLS.PeerSubject == "" || sr.TLS.PeerNotAfter == "" {
+		t.Errorf("Expected TLS details, got %+v", sr.TLS)
+	}
+}
+
+func TestLastResponseRequestInTemplate(t *testing.T) {
+	echo := &echoTransport{}
+	RegisterTransport("echo-request", func(tr *TemplateRequest) (Transport, error) { return echo, nil })
+
+	tr := &TemplateRequest{
+		URL:           "echo-request://local",
+		Body:          `{{with .LastResponse.Request.Body}}{{.}}-{{end}}{{.Page}}`,
+		MaxIterations: 2,
+	}
+
+	bodies := []string{}
+	tr.Recurse(&RequestContext{}, func(body []byte) { bodies = append(bodies, string(body)) })
+
+	if len(bodies) != 2 || bodies[1] != "1-2" {
+		t.Errorf("Expected the previous request body in the template, got %v", bodies)
+	}
+}
+
+func TestRequestEchoRedacted(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	tr := &TemplateRequest{
+		Method: "POST",
+		URL:    server.URL + "/login?token={{.AuthToken}}",
+		Headers: map[string]string{
+			"Authorization": "Token {{.AuthToken}}",
+			"Cookie":        "session=abc",
+			"X-Token":       "{{.AuthToken}}",
+		},
+		Body: `{"token": "{{.AuthToken}}"}`,
+	}
+
+	if _, _, err := tr.Send(&RequestContext{AuthToken: "s3cret"}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if echoed := tr.LastResponse.Request; echoed.Query.Get("token") != "s3cret" || echoed.Headers["Authorization"][0] != "Token s3cret" {
+		t.Errorf("Expected conditions to see the request as sent, got %v %v", echoed.Query, echoed.Headers)
+	}
+
+	req := tr.LastResponse.Request.Redact("s3cret")
+	for _, name := range []string{"Authorization", "Cookie", "X-Token"} {
+		if values := req.Headers[name]; len(values) != 1 || values[0] != Redacted {
+			t.Errorf("Expected %s to be redacted, got %v", name, values)
+		}
+	}
+	if req.Body != `{"token": "[REDACTED]"}` {
+		t.Errorf("Expected the token to be redacted from the body, got %s", req.Body)
+	}
+	if strings.Contains(req.URL, "s3cret") || req.Query.Get("token") != Redacted {
+		t.Errorf("Expected the token to be redacted from the URL, got %s %v", req.URL, req.Query)
+	}
+	if tr.LastResponse.Request.Query.Get("token") != "s3cret" {
+		t.Error("Expected Redact to leave the echo itself alone")
+	}
+
+	cp := tr.checkpoint(&RequestContext{}, 1, false)
+	if cp.LastResponse.Request.URL != "" {
+		t.Errorf("Expected no request echo in the checkpoint, got %+v", cp.LastResponse.Request)
+	}
+}
@@ -0,0 +1,96 @@
+package request
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"syscall"
+	"time"
+)
+
+// Retry resends requests that fail with a network error or come back with
+// one of the listed statuses. The delay doubles after every attempt.
+//
+//	retry:
+//	  max: 3
+//	  delay: 500ms
+//	  status: [429, 502, 503]
+type Retry struct {
+	// Max is how many times a request is resent, zero disables retries.
+	Max int `yaml:"max"`
+	// Delay is the wait before the first retry.
+	Delay Duration `yaml:"delay"`
+	// Status lists response statuses that are retried like errors.
+	Status []int `yaml:"status"`
+}
+
+// reason returns why the outcome of a request should be retried, nil when
+// it should not.
+func (r Retry) reason(sr *SimpleResponse, err error) error {
+	if err != nil {
+		if retryable(err) {
+			return err
+		}
+		return nil
+	}
+	if sr != nil && slices.Contains(r.Status, sr.Status) {
+		return fmt.Errorf("retryable status %d", sr.Status)
+	}
+	return nil
+}
+
+// retryable reports whether err is a network error another attempt may get
+// past: a timeout, a refused, reset or aborted connection, or a connection
+// closed early. Errors of hooks, middleware or limits like max_body_size
+// would fail the same way again.
+func retryable(err error) bool {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	for _, target := range []error{syscall.ECONNRESET, syscall.ECONNREFUSED, syscall.ECONNABORTED, syscall.EPIPE, io.EOF, io.ErrUnexpectedEOF} {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// roundTrip sends rendered over t, retrying as the template's retry settings
+// say. When the retries run out the last outcome is returned as it is.
+func (tr *TemplateRequest) roundTrip(ctx context.Context, c *RequestContext, t Transport, rendered *RenderedRequest) (*SimpleResponse, error) {
+	delay := time.Duration(tr.Retry.Delay)
+	for attempt := 1; ; attempt++ {
+		sr, err := t.RoundTrip(ctx, c, rendered)
+		reason := tr.Retry.reason(sr, err)
+		if reason == nil || attempt > tr.Retry.Max || ctx.Err() != nil {
+			return sr, err
+		}
+
+		for _, h := range tr.hooks() {
+			if h.OnRetry != nil {
+				h.OnRetry(ctx, c, attempt, reason)
+			}
+		}
+
+		if delay > 0 {
+			timer := time.NewTimer(delay)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return sr, err
+			}
+			delay *= 2
+		}
+
+		if sr != nil && sr.Streamed && tr.BodySink != nil {
+			if err := tr.BodySink.Discard(c, sr); err != nil {
+				tr.logger().Println(err)
+			}
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package request
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+type flakyTransport struct {
+	failures int
+	calls    int
+}
+
+func (t *flakyTransport) RoundTrip(ctx context.Context, c *RequestContext, req *RenderedRequest) (*SimpleResponse, error) {
+	t.calls++
+	if t.calls <= t.failures {
+		return nil, &net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}
+	}
+	return &SimpleResponse{Status: 200, Body: req.Body}, nil
+}
+
+func (t *flakyTransport) Close() error { return nil }
+
+func TestRetryReason(t *testing.T) {
+	retry := Retry{Status: []int{429, 503}}
+
+	if retry.reason(&SimpleResponse{Status: 200}, nil) != nil {
+		t.Error("Expected no retry for 200")
+	}
+	if retry.reason(&SimpleResponse{Status: 503}, nil) == nil {
+		t.Error("Expected a retry for 503")
+	}
+
+	for _, err := range []error{
+		&net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED},
+		fmt.Errorf("read body: %w", io.ErrUnexpectedEOF),
+		context.DeadlineExceeded,
+	} {
+		if retry.reason(nil, err) == nil {
+			t.Errorf("Expected a retry for %v", err)
+		}
+	}
+	for _, err := range []error{
+		errors.New("signing failed"),
+		fmt.Errorf("%w (%d bytes)", ErrBodyTooLarge, 10),
+	} {
+		if retry.reason(nil, err) != nil {
+			t.Errorf("Expected no retry for %v", err)
+		}
+	}
+}
+
+func TestRetryTransportErrors(t *testing.T) {
+	flaky := &flakyTransport{failures: 2}
+	RegisterTransport("flaky", func(tr *TemplateRequest) (Transport, error) { return flaky, nil })
+
+	tr := &TemplateRequest{
+		URL:           "flaky://local",
+		Body:          `{{.Page}}`,
+		MaxIterations: 1,
+		Retry:         Retry{Max: 3, Delay: Duration(time.Millisecond)},
+	}
+
+	attempts := []int{}
+	runner := NewRunner(WithHooks(Hooks{
+		OnRetry: func(ctx context.Context, c *RequestContext, attempt int, err error) {
+			attempts = append(attempts, attempt)
+		},
+	}))
+
+	results := []*Result{}
+	for res := range runner.All(context.Background(), tr, nil) {
+		results = append(results, res)
+	}
+
+	if len(results) != 1 || results[0].Err != nil || string(results[0].Body) != "1" {
+		t.Fatalf("Expected the request to succeed on the third attempt, got %+v", results)
+	}
+	if len(attempts) != 2 || attempts[0] != 1 || attempts[1] != 2 {
+		t.Errorf("Expected OnRetry after attempts 1 and 2, got %v", attempts)
+	}
+}
+
+func TestRetryGivesUp(t *testing.T) {
+	flaky := &flakyTransport{failures: 5}
+	RegisterTransport("flaky-give-up", func(tr *TemplateRequest) (Transport, error) { return flaky, nil })
+
+	tr := &TemplateRequest{
+		URL:   "flaky-give-up://local",
+		Retry: Retry{Max: 2},
+	}
+
+	var onError error
+	runner := NewRunner(WithHooks(Hooks{
+		OnError: func(ctx context.Context, c *RequestContext, err error) { onError = err },
+	}))
+
+	var last *Result
+	for res := range runner.All(context.Background(), tr, nil) {
+		last = res
+	}
+
+	if flaky.calls != 3 {
+		t.Errorf("Expected 3 attempts, got %d", flaky.calls)
+	}
+	if last == nil || last.Err == nil {
+		t.Fatalf("Expected an error result, got %+v", last)
+	}
+	if !errors.Is(onError, syscall.ECONNRESET) {
+		t.Errorf("Expected OnError with the last error, got %v", onError)
+	}
+}
+
+func TestRetryStatus(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	tr := &TemplateRequest{
+		URL:           server.URL,
+		Method:        "GET",
+		MaxIterations: 1,
+		Retry:         Retry{Max: 1, Status: []int{503}},
+	}
+
+	bodies := []string{}
+	tr.Recurse(&RequestContext{}, func(body []byte) { bodies = append(bodies, string(body)) })
+
+	if calls != 2 || len(bodies) != 1 || bodies[0] != "ok" {
+		t.Errorf("Expected the 503 to be retried, got %d calls and %v", calls, bodies)
+	}
+}
+
+func TestRetrySkipsPermanentErrors(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Write([]byte(strings.Repeat("a", 100)))
+	}))
+	defer server.Close()
+
+	tr := &TemplateRequest{
+		URL:         server.URL,
+		Method:      "GET",
+		MaxBodySize: 10,
+		OnOversize:  OversizeAbort,
+		Retry:       Retry{Max: 2},
+	}
+	if _, _, err := tr.Send(&RequestContext{}); !errors.Is(err, ErrBodyTooLarge) {
+		t.Errorf("Expected ErrBodyTooLarge, got %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("Expected an oversized body not to be retried, got %d calls", calls)
+	}
+
+	hookCalls := 0
+	runner := NewRunner(WithHooks(Hooks{
+		BeforeRequest: func(ctx context.Context, c *RequestContext, req *http.Request) error {
+			hookCalls++
+			return errors.New("signing failed")
+		},
+	}))
+	tr = &TemplateRequest{URL: server.URL, Method: "GET", Retry: Retry{Max: 2}}
+
+	var last *Result
+	for res := range runner.All(context.Background(), tr, nil) {
+		last = res
+	}
+	if last == nil || last.Err == nil {
+		t.Fatalf("Expected an error result, got %+v", last)
+	}
+	if hookCalls != 1 {
+		t.Errorf("Expected a hook error not to be retried, got %d calls", hookCalls)
+	}
+}
@@ -1,21 +1,18 @@
 package request
 
 import (
-	"bytes"
-	"crypto/tls"
-	"encoding/json"
+	"context"
 	"errors"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
 	"net/url"
-	"os"
 	"regexp"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"text/template"
 
-	"github.com/gorilla/websocket"
 	"github.com/itchyny/gojq"
 	"gopkg.in/yaml.v3"
 )
@@ -26,14 +23,72 @@ type HeaderTemplate struct {
 }
 
 type TemplateRequest struct {
-	Name      string            `yaml:"name"`
-	URL       string            `yaml:"url"`
-	Headers   map[string]string `yaml:"headers"`
-	SetupBody string            `yaml:"setup_body"`
-	Body      string            `yaml:"body"`
-	Method    string            `yaml:"method"`
-	StopWhen  []string          `yaml:"stop_when"`
-	Lists     [][]string        `yaml:"lists"`
+	Name          string            `yaml:"name"`
+	Description   string            `yaml:"description"`
+	URL           string            `yaml:"url"`
+	Headers       map[string]string `yaml:"headers"`
+	SetupBody     string            `yaml:"setup_body"`
+	Body          string            `yaml:"body"`
+	Method        string            `yaml:"method"`
+	StopWhen      []string          `yaml:"stop_when"`
+	ContinueWhile []string          `yaml:"continue_while"`
+	StopUnless    []string          `yaml:"stop_unless"`
+	// Combinators holds the any, all and none form of the condition fields,
+	// checked on top of their plain lists.
+	Combinators   Combinators       `yaml:"-"`
+	MaxIterations int               `yaml:"max_iterations"`
+	Lists         [][]string        `yaml:"lists"`
+	CookieJar     bool              `yaml:"cookie_jar"`
+	Baseline      *Baseline         `yaml:"baseline"`
+	Match         *Matcher          `yaml:"match"`
+	Filter        *Matcher          `yaml:"filter"`
+	Extract       map[string]string `yaml:"extract"`
+	BodyFormat    string            `yaml:"body_format"`
+	// DisableDecoding leaves compressed and non UTF-8 bodies as received.
+	DisableDecoding bool `yaml:"disable_decoding"`
+	// KeepOriginalBody keeps the bytes as received next to the decoded body.
+	KeepOriginalBody bool `yaml:"keep_original_body"`
+	// MaxBodySize caps how much of a body is read, zero means no limit.
+	MaxBodySize ByteSize `yaml:"max_body_size"`
+	// OnOversize is "truncate" (default) or "abort".
+	OnOversize string `yaml:"on_oversize"`
+	// StreamPrefix is how much of a streamed body conditions and matchers
+	// see, DefaultStreamPrefix when zero.
+	StreamPrefix ByteSize `yaml:"stream_prefix"`
+	// Timeouts bound the phases of each request, none are set by default.
+	Timeouts Timeouts `yaml:"timeouts"`
+	// Connection configures connection reuse and the HTTP version.
+	Connection Connection `yaml:"connection"`
+	Redirects  Redirects  `yaml:"redirects"`
+	TLS        TLSConfig  `yaml:"tls"`
+	// Retry resends failed requests, none are by default.
+	Retry Retry `yaml:"retry"`
+	// Middleware names registered middleware to wrap HTTP requests in, the
+	// first one outermost.
+	Middleware []MiddlewareConfig `yaml:"middleware"`
+	// History is how many previous responses .History and $history keep.
+	History int `yaml:"history"`
+	// StopOnRepeat stops the run when a response body comes back again,
+	// like APIs that return the last page forever.
+	StopOnRepeat bool `yaml:"stop_on_repeat"`
+	// Collect is a jq expression run against each parsed body, its results
+	// are gathered into one output, e.g. ".data.items[]".
+	Collect string `yaml:"collect"`
+	// CollectKey is a jq expression run against each collected item, items
+	// with a key that was seen before are dropped.
+	CollectKey string `yaml:"collect_key"`
+
+	// File is the file the template was loaded from, if any.
+	File string `yaml:"-"`
+	// CheckpointFile is where Recurse periodically persists its progress.
+	CheckpointFile string `yaml:"-"`
+	// CheckpointEvery is the number of iterations between checkpoints.
+	CheckpointEvery int `yaml:"-"`
+	// BodySink, when set, receives HTTP bodies as they are read instead of
+	// them being held in memory.
+	BodySink BodySink `yaml:"-"`
+	// Logger is where the run logs to, the standard logger when nil.
+	Logger *log.Logger `yaml:"-"`
 
 	headerTemplates map[string]*HeaderTemplate
 	bodyTemplate    *template.Template
@@ -41,9 +96,33 @@ type TemplateRequest struct {
 
 	LastResponse SimpleResponse
 
-	webSocket *websocket.Conn
+	openTransports map[string]Transport
+	client         *http.Client
+	// baseTransport is the base of client, below the middleware
+	baseTransport *http.Transport
+	middleware    []Middleware
+	// runClient is the client of a run of a Runner with its own client or
+	// middleware
+	runClient *http.Client
 
 	proxyURL *url.URL
+
+	compileOnce  sync.Once
+	compileErr   error
+	extractCodes map[string]*gojq.Code
+
+	stopWhen, continueWhile, stopUnless compiledConditions
+
+	collectCode    *gojq.Code
+	collectKeyCode *gojq.Code
+
+	jar        *recordingJar
+	resumeFrom int
+	resumeDone bool
+
+	// runner is the Runner of the current run, if any
+	runner  *Runner
+	running atomic.Bool
 }
 
 func CreateTemplate(name, t string) *template.Template {
@@ -116,304 +195,366 @@ type RequestContext struct {
 	Extra        map[string]interface{}
 	ListParams   []string
 	LastResponse *SimpleResponse
+	// History holds the previous responses, newest first, up to the
+	// template's history setting.
+	History []*SimpleResponse
 }
 
+// Send renders the request, sends it over the transport registered for the
+// URL scheme and evaluates the response.
 func (tr *TemplateRequest) Send(c *RequestContext) ([]byte, bool, error) {
-
-	var bodyBytes bytes.Buffer
-	tr.BodyTemplate().Execute(&bodyBytes, c)
-
-	var urlBytes bytes.Buffer
-	tr.URLTemplate().Execute(&urlBytes, c)
-
-	requestURL := urlBytes.String()
-	httpHeader := http.Header{}
-
-	for _, headerTpl := range tr.HeaderTemplates() {
-		var hdrBytes bytes.Buffer
-		var valBytes bytes.Buffer
-		err := headerTpl.HeaderTemplate.Execute(&hdrBytes, c)
-		if err != nil {
-			panic(err)
-		}
-		err = headerTpl.ValueTemplate.Execute(&valBytes, c)
-		if err != nil {
-			panic(err)
-		}
-
-		httpHeader.Set(hdrBytes.String(), valBytes.String())
-	}
-
-	if strings.HasPrefix(requestURL, "http") {
-		// we are working HTTP
-
-		req, err := http.NewRequest(tr.Method, requestURL, &bodyBytes)
-		if err != nil {
-			return nil, false, err
-		}
-		req.Header = httpHeader
-		client := &http.Client{}
-
-		if tr.proxyURL != nil {
-			tlsConfig := &tls.Config{
-				InsecureSkipVerify: true, // This disables certificate verification
-			}
-
-			proxy := http.ProxyURL(tr.proxyURL)
-			transport := &http.Transport{
-				Proxy:           proxy,
-				TLSClientConfig: tlsConfig,
-			}
-			client.Transport = transport
-		}
-
-		resp, err := client.Do(req)
-		if err != nil {
-			return nil, false, err
-		}
-		defer resp.Body.Close()
-		body, err := io.ReadAll(resp.Body)
-		if err != nil {
-			return nil, false, err
-		}
-
-		shouldContinue := tr.ShouldContinueHTTP(resp, body)
-		return body, shouldContinue, nil
-	} else if strings.HasPrefix(requestURL, "ws:") {
-		// we are working with websockets!!
-		//parsedProxy, err := url.Parse("http://127.0.0.1:8080")
-		//websocket.DefaultDialer.Proxy = http.ProxyURL(parsedProxy)
-		ws := tr.getWS(requestURL, httpHeader)
-
-		if c.Iteration == 0 && tr.SetupBody != "" {
-			// hack to test if this could be useful
-			if err := ws.WriteMessage(websocket.TextMessage, []byte(tr.SetupBody)); err != nil {
-				return nil, false, err
-			}
-
-			if _, msg, err := ws.ReadMessage(); err != nil {
-				log.Fatal(err)
-			} else {
-				log.Println(string(msg))
-			}
-		}
-
-		if err := ws.WriteMessage(websocket.TextMessage, bodyBytes.Bytes()); err != nil {
-			return nil, false, err
-		}
-
-		if _, msg, err := ws.ReadMessage(); err != nil {
-			log.Fatal(err)
-		} else {
-			shouldContinue := tr.ShouldContinueWS(msg)
-			return msg, shouldContinue, nil
-		}
-	}
-
-	return nil, false, errors.New("invalid request")
-}
-
-func (tr *TemplateRequest) getWS(requestURL string, httpHeader http.Header) *websocket.Conn {
-	if tr.webSocket == nil {
-		ws, _, err := websocket.DefaultDialer.Dial(requestURL, httpHeader)
-		if err != nil {
-			panic(err)
-		}
-		tr.webSocket = ws
-	}
-	return tr.webSocket
+	return tr.SendContext(context.Background(), c)
 }
 
-func (tr *TemplateRequest) ShouldContinueHTTP(resp *http.Response, body []byte) bool {
-	if tr.StopWhen == nil || len(tr.StopWhen) == 0 {
-		// no conditions. do not continue
-		return false
-	}
-
-	sr := SimpleResponse{
-		Request: SimpleRequest{
-			Path:  resp.Request.URL.Path,
-			Query: resp.Request.URL.Query(),
-		},
-		Status:      resp.StatusCode,
-		RawBody:     string(body),
-		ContentType: resp.Header.Get("Content-Type"),
-		Headers:     resp.Header,
+// SendContext is Send with a context that bounds the request.
+func (tr *TemplateRequest) SendContext(ctx context.Context, c *RequestContext) ([]byte, bool, error) {
+	if err := tr.compiled(); err != nil {
+		return nil, false, err
 	}
 
-	maybe := map[string]any{}
-	json.Unmarshal(body, &maybe)
-
-	maybeNot := []map[string]any{}
-	json.Unmarshal(body, &maybeNot)
-
-	sr.BodyObject = maybe
-	sr.BodyArray = maybeNot
-
-	jsonM, err := json.Marshal(sr)
+	rendered, err := tr.Render(c)
 	if err != nil {
-		log.Println("error marshalling json of simple request", err)
-		panic(err)
+		return nil, false, err
 	}
-	r := map[string]any{}
-	err = json.Unmarshal(jsonM, &r)
+
+	transport, err := tr.transport(rendered.URL.Scheme)
 	if err != nil {
-		log.Println("error unmarshalling json of simple request", err)
-		panic(err)
+		return nil, false, err
 	}
 
-	tr.LastResponse = sr
-
-	for _, condition := range tr.StopWhen {
-		query, err := gojq.Parse(condition)
-		if err != nil {
-			log.Println(err)
-			panic(err)
-		}
-
-		iter := query.Run(r)
-		for {
-			v, ok := iter.Next()
-			if !ok {
-				break
-			}
-			if err, ok := v.(error); ok {
-				if err, ok := err.(*gojq.HaltError); ok && err.Value() == nil {
-					break
-				}
-			}
-
-			if v != nil {
-				return false
-			}
-		}
+	sr, err := tr.roundTrip(ctx, c, transport, rendered)
+	if err != nil {
+		return nil, false, err
 	}
+	sr.Request.echo(rendered, c)
 
-	// no matches, continue
-	return true
+	shouldContinue := tr.Evaluate(c, sr)
+	return sr.Body, shouldContinue, nil
 }
 
-func (tr *TemplateRequest) ShouldContinueWS(body []byte) bool {
-	if tr.StopWhen == nil || len(tr.StopWhen) == 0 {
-		// no conditions. do not continue
+// Evaluate runs a response through the pipeline every transport shares:
+// body parsing, anomaly scoring, extraction and the loop conditions. It
+// stores the response as LastResponse and reports whether to continue.
+func (tr *TemplateRequest) Evaluate(c *RequestContext, sr *SimpleResponse) bool {
+	if err := tr.compiled(); err != nil {
+		tr.logger().Println(err)
 		return false
 	}
 
-	sr := SimpleResponse{
-		RawBody: string(body),
+	if sr.Size == 0 {
+		sr.Size = int64(len(sr.Body))
 	}
+	if sr.BodyHash == "" {
+		sr.BodyHash = hashBody(sr.Body)
+	}
+	sr.RawBody = string(sr.Body)
+	sr.parseBody(tr.BodyFormat, sr.Body)
 
-	maybe := map[string]any{}
-	json.Unmarshal(body, &maybe)
-
-	maybeNot := []map[string]any{}
-	json.Unmarshal(body, &maybeNot)
-
-	sr.BodyObject = maybe
-	sr.BodyArray = maybeNot
-
-	jsonM, err := json.Marshal(sr)
-	if err != nil {
-		log.Println("error marshalling json of simple request", err)
-		panic(err)
+	if tr.Baseline != nil {
+		sr.Anomaly = tr.Baseline.Score(sr)
 	}
-	r := map[string]any{}
-	err = json.Unmarshal(jsonM, &r)
-	if err != nil {
-		log.Println("error unmarshalling json of simple request", err)
-		panic(err)
+
+	// a repeated body ends the run, it was collected and reported the
+	// first time it came back
+	sr.repeated = tr.stopOnRepeat(c, tr.LastResponse.BodyHash, sr)
+	if !sr.repeated {
+		tr.collect(c, sr)
 	}
 
-	for _, condition := range tr.StopWhen {
-		query, err := gojq.Parse(condition)
-		if err != nil {
-			log.Println(err)
-			panic(err)
-		}
+	// keep the response around even without conditions so output handlers
+	// and the next request's templates can use it
+	tr.LastResponse = *sr
+	// $history holds the responses before this one
+	defer tr.pushHistory(c, sr)
+
+	in := newJQInput(c, sr)
+	if len(tr.Extract) > 0 {
+		tr.extract(c, in)
+		// conditions see the freshly extracted values
+		in.vars = sr.jqVariables(c)
+	}
 
-		iter := query.Run(r)
-		for {
-			v, ok := iter.Next()
-			if !ok {
-				break
-			}
-			if err, ok := v.(error); ok {
-				if err, ok := err.(*gojq.HaltError); ok && err.Value() == nil {
-					break
-				}
-			}
+	if sr.repeated {
+		return false
+	}
 
-			if v != nil {
-				return false
-			}
-		}
+	if !tr.hasConditions() {
+		// no conditions. keep going through the lists or up to max_iterations,
+		// otherwise do not continue
+		return len(tr.Lists) > 0 || tr.MaxIterations > 0
 	}
 
-	// no matches, continue
-	return true
+	return !tr.shouldStop(in)
 }
 
 type SimpleRequest struct {
 	Path  string     `json:"path"`
 	Query url.Values `json:"query"`
+	// The rest echo the rendered request and the list values it was
+	// rendered with.
+	Method     string              `json:"method"`
+	URL        string              `json:"url"`
+	Host       string              `json:"host"`
+	Headers    map[string][]string `json:"headers"`
+	Body       string              `json:"body"`
+	ListParams []string            `json:"list_params"`
 }
 
 type SimpleResponse struct {
 	Request     SimpleRequest       `json:"request"`
 	Status      int                 `json:"status"`
+	Body        []byte              `json:"-"`
 	RawBody     string              `json:"raw_body"`
 	BodyObject  any                 `json:"body_object"`
 	BodyArray   any                 `json:"body_array"`
 	ContentType string              `json:"content_type"`
 	Headers     map[string][]string `json:"headers"`
+	Anomaly     *Anomaly            `json:"anomaly"`
+	TimeMS      int64               `json:"time_ms"`
+
+	// OriginalBody is the body before decompression and charset conversion,
+	// only set when the template keeps it.
+	OriginalBody []byte `json:"-"`
+	// Size is the length of the whole body, Body only holds a prefix of it
+	// when the body was streamed to a BodySink.
+	Size int64 `json:"size"`
+	// Truncated is set when the body was cut off at max_body_size, or when
+	// Body only holds the stream_prefix of a streamed body.
+	Truncated bool `json:"truncated"`
+	// Streamed is set when the body went to the template's BodySink.
+	Streamed bool `json:"-"`
+	// repeated is set when stop_on_repeat ended the run on this body.
+	repeated bool
+	// html caches the body parsed by the HTML jq functions.
+	html *htmlDoc
+	// BodyHash is the hex SHA-256 of the whole body.
+	BodyHash string `json:"body_hash"`
+	// Collected holds the results of the template's collect expression.
+	Collected []CollectedItem `json:"-"`
+
+	// body is the parsed body, whatever its top-level type
+	body any
+	// http is the HTTP response, its body already read
+	http *http.Response
+	// Redirects are the hops followed before this response, oldest first.
+	Redirects []Redirect `json:"redirects"`
+	// Timing breaks TimeMS down into the phases of the request.
+	Timing Timing `json:"timing"`
+	// Cookies are the parsed Set-Cookie headers.
+	Cookies    []Cookie `json:"cookies"`
+	RemoteAddr string   `json:"remote_addr"`
+	// Proto is the protocol version, e.g. "HTTP/2.0".
+	Proto string   `json:"proto"`
+	TLS   *TLSInfo `json:"tls"`
+}
+
+// size is the body length, falling back to RawBody for responses that were
+// not read by a transport.
+func (sr *SimpleResponse) size() int {
+	if sr.Size > 0 {
+		return int(sr.Size)
+	}
+	return len(sr.RawBody)
+}
+
+// Recurse sends requests until the template's conditions stop it, handing
+// reported bodies to handleResponse. It returns the error that ended the
+// run, if any.
+func (tr *TemplateRequest) Recurse(c *RequestContext, handleResponse func(body []byte)) error {
+	return tr.RecurseContext(context.Background(), c, handleResponse)
+}
+
+// RecurseContext is Recurse with a context. When the context is done the run
+// stops after saving a checkpoint that resumes at the interrupted iteration.
+func (tr *TemplateRequest) RecurseContext(ctx context.Context, c *RequestContext, handleResponse func(body []byte)) error {
+	done, err := tr.start(nil)
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	return tr.loop(ctx, c, nil, func(res *Result) bool {
+		if res.Reported {
+			handleResponse(res.Body)
+		}
+		return true
+	})
+}
+
+// start marks tr as running for runner, which may be nil, until done is
+// called.
+func (tr *TemplateRequest) start(runner *Runner) (done func(), err error) {
+	if !tr.running.CompareAndSwap(false, true) {
+		return nil, ErrTemplateRunning
+	}
+	tr.runner = runner
+	return func() {
+		tr.runner = nil
+		tr.runClient = nil
+		tr.running.Store(false)
+	}, nil
 }
 
-func (tr *TemplateRequest) Recurse(c *RequestContext, handleResponse func(body []byte)) {
-	for reqCount := 0; true; reqCount++ {
+// loop sends requests until the template's conditions stop it, calling each
+// with the result of every iteration. wait, when set, is called before each
+// request. each returns false to end the run early, which saves a checkpoint
+// resuming at the next iteration. A run stopped by ctx returns nil, errors
+// are from sending a request.
+func (tr *TemplateRequest) loop(ctx context.Context, c *RequestContext, wait func(context.Context) error, each func(res *Result) bool) error {
+	if tr.resumeDone {
+		tr.logger().Println("checkpoint is already complete, nothing to resume")
+		return nil
+	}
+
+	defer tr.Close()
+
+	for reqCount := tr.resumeFrom; true; reqCount++ {
 		c.Iteration = reqCount
 		c.Page = reqCount + 1
 		c.ResultOffset = c.PageSize * reqCount
 		c.LastResponse = &tr.LastResponse
 
 		if len(tr.Lists) > 0 {
+			if tr.listsExhausted(reqCount) {
+				tr.saveCheckpoint(c, reqCount, true)
+				return nil
+			}
+
 			c.ListParams = []string{}
 			for _, list := range tr.Lists {
 				if val := list[reqCount]; val != "" {
 					c.ListParams = append(c.ListParams, val)
 				} else {
-					log.Printf("list[%d] is empty", reqCount)
+					tr.logger().Printf("list[%d] is empty", reqCount)
 				}
 			}
 		}
 
-		body, shouldContinue, err := tr.Send(c)
+		if wait != nil && ctx.Err() == nil {
+			if err := wait(ctx); err != nil && ctx.Err() == nil {
+				return err
+			}
+		}
+
+		if ctx.Err() != nil {
+			tr.stopped(ctx, c, reqCount)
+			return nil
+		}
+
+		body, shouldContinue, err := tr.SendContext(ctx, c)
 		if err != nil {
-			panic(err)
+			if ctx.Err() != nil {
+				tr.stopped(ctx, c, reqCount)
+				return nil
+			}
+			return err
 		}
 
-		handleResponse(body)
+		res := tr.result(c, body, shouldContinue)
+		if !res.Reported && tr.BodySink != nil && tr.LastResponse.Streamed {
+			if err := tr.BodySink.Discard(c, &tr.LastResponse); err != nil {
+				tr.logger().Println(err)
+			}
+		}
+
+		if tr.MaxIterations > 0 && reqCount+1 >= tr.MaxIterations {
+			shouldContinue = false
+			res.Continue = false
+		}
+
+		if !each(res) && shouldContinue {
+			tr.writeCheckpoint(c, reqCount+1, false)
+			return nil
+		}
+
+		tr.saveCheckpoint(c, reqCount+1, !shouldContinue)
 
 		if !shouldContinue {
-			return
+			return nil
+		}
+	}
+	return nil
+}
+
+// stopped records a run cut short by its context so it can be resumed at
+// iteration next.
+func (tr *TemplateRequest) stopped(ctx context.Context, c *RequestContext, next int) {
+	tr.logger().Printf("stopping at iteration %d: %v", next, context.Cause(ctx))
+	tr.writeCheckpoint(c, next, false)
+}
+
+func (tr *TemplateRequest) listsExhausted(reqCount int) bool {
+	for _, list := range tr.Lists {
+		if reqCount >= len(list) {
+			return true
 		}
 	}
+	return false
 }
 
+// FromFile loads a template from a file. Files it extends or includes are
+// looked up relative to it.
 func FromFile(filename string) (*TemplateRequest, error) {
-	f, err := os.ReadFile(filename)
+	node, err := (&templateLoader{}).loadFile(filename)
 	if err != nil {
 		return nil, err
 	}
 
-	return FromBytes(f)
+	tr, err := fromNode(node)
+	if err != nil {
+		return nil, err
+	}
+	tr.File = filename
+	return tr, nil
 }
 
+// FromFileAll loads every template of a multi-document YAML file.
+func FromFileAll(filename string) ([]*TemplateRequest, error) {
+	nodes, err := (&templateLoader{}).loadFileAll(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	templates := make([]*TemplateRequest, 0, len(nodes))
+	for i, node := range nodes {
+		tr, err := fromNode(node)
+		if err != nil {
+			return nil, fmt.Errorf("%s: template %d: %w", filename, i+1, err)
+		}
+		tr.File = filename
+		templates = append(templates, tr)
+	}
+	return templates, nil
+}
+
+// FromBytes loads a template. Files it extends or includes are looked up
+// relative to the working directory.
 func FromBytes(fileByes []byte) (*TemplateRequest, error) {
+	node, err := (&templateLoader{}).load(fileByes, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	return fromNode(node)
+}
+
+func fromNode(node *yaml.Node) (*TemplateRequest, error) {
+	if node == nil {
+		return nil, errors.New("empty template")
+	}
+	clearReplaceTags(node)
+
 	var request *TemplateRequest
-	err := yaml.Unmarshal(fileByes, &request)
+	err := node.Decode(&request)
 	if err != nil {
 		return nil, err
 	}
+	if request == nil {
+		return nil, errors.New("empty template")
+	}
+	if err := request.Compile(); err != nil {
+		return nil, err
+	}
 	return request, nil
 }
 
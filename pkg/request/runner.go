@@ -0,0 +1,308 @@
+package request
+
+import (
+	"context"
+	"errors"
+	"iter"
+	"log"
+	"net/http"
+	"sync"
+)
+
+// ErrTemplateRunning is returned when a template is run while another run
+// of the same template is in progress. A template holds the state of its
+// run, so each template can only run once at a time.
+var ErrTemplateRunning = errors.New("template is already running")
+
+// Result is what a run reports for each iteration.
+type Result struct {
+	// Template is the name of the template that produced the result.
+	Template   string
+	Iteration  int
+	Page       int
+	ListParams []string
+	// Response is the response of this iteration. It is not modified once
+	// the result is emitted and can be kept.
+	Response *SimpleResponse
+	// Body is the response body, only a prefix of it when it was streamed
+	// to a BodySink.
+	Body []byte
+	// HTTPResponse is the response as the HTTP client returned it, with its
+	// body already read. It is nil for other transports.
+	HTTPResponse *http.Response
+	// Reported is set when the response passed the match and filter rules.
+	Reported bool
+	// Continue is set when the run goes on after this iteration.
+	Continue bool
+	// Err is set when the request failed, it is the last result of the run.
+	Err error
+}
+
+func (tr *TemplateRequest) result(c *RequestContext, body []byte, shouldContinue bool) *Result {
+	resp := tr.LastResponse
+	return &Result{
+		Template:     tr.Name,
+		Iteration:    c.Iteration,
+		Page:         c.Page,
+		ListParams:   c.ListParams,
+		Response:     &resp,
+		Body:         body,
+		HTTPResponse: resp.http,
+		Reported:     tr.Report(c, &tr.LastResponse),
+		Continue:     shouldContinue,
+	}
+}
+
+// RateLimiter paces requests. Wait blocks until the next request may be
+// sent. *rate.Limiter from golang.org/x/time/rate satisfies it.
+type RateLimiter interface {
+	Wait(ctx context.Context) error
+}
+
+// Sink receives the reported responses of a run. *output.Writer satisfies
+// it.
+type Sink interface {
+	Write(c *RequestContext, resp *SimpleResponse, body []byte) error
+}
+
+// Hooks are called at points of a run. Any of them can be nil.
+type Hooks struct {
+	// BeforeRequest is called with every HTTP request just before it is
+	// sent and can change it, e.g. to sign it. An error fails the request.
+	BeforeRequest func(ctx context.Context, c *RequestContext, req *http.Request) error
+	// AfterResponse is called with every result before it is emitted, once
+	// the response went through the template's conditions.
+	AfterResponse func(ctx context.Context, res *Result)
+	// OnRetry is called before a request is resent, attempt is the number
+	// of the attempt that failed, starting at 1.
+	OnRetry func(ctx context.Context, c *RequestContext, attempt int, err error)
+	// OnError is called when a request fails for good, which ends the run.
+	OnError func(ctx context.Context, c *RequestContext, err error)
+	// OnStop is called once a run ends. err is nil when the template's
+	// conditions ended it, otherwise why it stopped early.
+	OnStop func(ctx context.Context, tr *TemplateRequest, err error)
+}
+
+// ErrStopped is passed to OnStop when the consumer of a run stopped reading
+// its results.
+var ErrStopped = errors.New("run stopped by the caller")
+
+// Runner runs templates and emits a Result for each iteration. A Runner is
+// configured once with options and can then run any number of templates,
+// concurrently too.
+//
+//	runner := request.NewRunner(
+//		request.WithLogger(logger),
+//		request.WithRateLimiter(rate.NewLimiter(10, 1)),
+//	)
+//	for res := range runner.All(ctx, tr, &request.RequestContext{Host: host}) {
+//		...
+//	}
+//
+// Sinks are written to one result at a time, so they do not need to be safe
+// for concurrent use themselves.
+type Runner struct {
+	client  *http.Client
+	logger  *log.Logger
+	limiter RateLimiter
+	sinks   []Sink
+	hooks   []Hooks
+	buffer  int
+
+	middleware []Middleware
+
+	sinkMu sync.Mutex
+}
+
+// Option configures a Runner.
+type Option func(*Runner)
+
+// WithClient sends HTTP requests with client instead of the client each
+// template builds for itself. The connection, redirects and cookie_jar
+// settings of templates do not apply to it.
+func WithClient(client *http.Client) Option {
+	return func(r *Runner) {
+		r.client = client
+	}
+}
+
+// WithMiddleware wraps the HTTP round trips of every template the Runner
+// runs in mw, outside of the templates' own middleware.
+func WithMiddleware(mw ...Middleware) Option {
+	return func(r *Runner) {
+		r.middleware = append(r.middleware, mw...)
+	}
+}
+
+// WithLogger logs to logger instead of the standard logger. A template's
+// own Logger takes precedence.
+func WithLogger(logger *log.Logger) Option {
+	return func(r *Runner) {
+		r.logger = logger
+	}
+}
+
+// WithRateLimiter waits on limiter before each request. One limiter paces
+// every run of the Runner together.
+func WithRateLimiter(limiter RateLimiter) Option {
+	return func(r *Runner) {
+		r.limiter = limiter
+	}
+}
+
+// WithSink writes reported responses to sink. It can be given more than
+// once.
+func WithSink(sink Sink) Option {
+	return func(r *Runner) {
+		r.sinks = append(r.sinks, sink)
+	}
+}
+
+// WithHooks adds hooks. It can be given more than once, hooks are called in
+// the order they were added.
+func WithHooks(hooks Hooks) Option {
+	return func(r *Runner) {
+		r.hooks = append(r.hooks, hooks)
+	}
+}
+
+// WithBuffer sets how many results Run buffers ahead of the reader.
+func WithBuffer(n int) Option {
+	return func(r *Runner) {
+		r.buffer = n
+	}
+}
+
+// NewRunner creates a Runner with opts applied.
+func NewRunner(opts ...Option) *Runner {
+	r := &Runner{}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// All runs tr and yields the result of every iteration. Breaking out of the
+// loop ends the run, saving a checkpoint that resumes at the next iteration
+// if the template has a checkpoint file. A nil c runs with an empty
+// RequestContext.
+func (r *Runner) All(ctx context.Context, tr *TemplateRequest, c *RequestContext) iter.Seq[*Result] {
+	return func(yield func(*Result) bool) {
+		if c == nil {
+			c = &RequestContext{}
+		}
+
+		var wait func(context.Context) error
+		if r.limiter != nil {
+			wait = r.limiter.Wait
+		}
+
+		stopped := false
+		done, err := tr.start(r)
+		if err == nil {
+			err = tr.loop(ctx, c, wait, func(res *Result) bool {
+				r.handle(ctx, c, res)
+				if !yield(res) {
+					stopped = true
+					return false
+				}
+				return true
+			})
+			done()
+		}
+
+		switch {
+		case err != nil:
+			for _, h := range r.hooks {
+				if h.OnError != nil {
+					h.OnError(ctx, c, err)
+				}
+			}
+			yield(&Result{
+				Template:   tr.Name,
+				Iteration:  c.Iteration,
+				Page:       c.Page,
+				ListParams: c.ListParams,
+				Err:        err,
+			})
+		case stopped:
+			err = ErrStopped
+		case ctx.Err() != nil:
+			err = context.Cause(ctx)
+		}
+
+		for _, h := range r.hooks {
+			if h.OnStop != nil {
+				h.OnStop(ctx, tr, err)
+			}
+		}
+	}
+}
+
+// Run runs tr in a new goroutine and sends the result of every iteration on
+// the returned channel, which is closed when the run ends. The reader has to
+// drain the channel or cancel ctx.
+func (r *Runner) Run(ctx context.Context, tr *TemplateRequest, c *RequestContext) <-chan *Result {
+	results := make(chan *Result, r.buffer)
+	go func() {
+		defer close(results)
+		for res := range r.All(ctx, tr, c) {
+			select {
+			case results <- res:
+			case <-ctx.Done():
+				// the reader may be gone, ending the run here saves a
+				// checkpoint at the next iteration
+				return
+			}
+		}
+	}()
+	return results
+}
+
+// handle calls the AfterResponse hooks and writes reported responses to the
+// sinks.
+func (r *Runner) handle(ctx context.Context, c *RequestContext, res *Result) {
+	for _, h := range r.hooks {
+		if h.AfterResponse != nil {
+			h.AfterResponse(ctx, res)
+		}
+	}
+
+	if !res.Reported || len(r.sinks) == 0 {
+		return
+	}
+
+	r.sinkMu.Lock()
+	defer r.sinkMu.Unlock()
+	for _, sink := range r.sinks {
+		if err := sink.Write(c, res.Response, res.Body); err != nil {
+			r.log().Println(err)
+		}
+	}
+}
+
+func (r *Runner) log() *log.Logger {
+	if r.logger != nil {
+		return r.logger
+	}
+	return log.Default()
+}
+
+// hooks are the hooks of the Runner running tr.
+func (tr *TemplateRequest) hooks() []Hooks {
+	if tr.runner == nil {
+		return nil
+	}
+	return tr.runner.hooks
+}
+
+// logger is where a template logs to.
+func (tr *TemplateRequest) logger() *log.Logger {
+	if tr.Logger != nil {
+		return tr.Logger
+	}
+	if tr.runner != nil {
+		return tr.runner.log()
+	}
+	return log.Default()
+}
@@ -0,0 +1,339 @@
+package request
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+type countingLimiter struct {
+	waits atomic.Int32
+}
+
+func (l *countingLimiter) Wait(ctx context.Context) error {
+	l.waits.Add(1)
+	return nil
+}
+
+type memorySink struct {
+	bodies []string
+}
+
+func (s *memorySink) Write(c *RequestContext, resp *SimpleResponse, body []byte) error {
+	s.bodies = append(s.bodies, fmt.Sprintf("%d:%s", c.Page, body))
+	return nil
+}
+
+func TestRunnerAll(t *testing.T) {
+	RegisterTransport("echo-runner", func(tr *TemplateRequest) (Transport, error) { return &echoTransport{}, nil })
+
+	tr := &TemplateRequest{
+		Name:          "pages",
+		URL:           "echo-runner://local",
+		Body:          `{"page": {{.Page}}}`,
+		MaxIterations: 3,
+		Filter:        &Matcher{JQ: []string{`.body_object.page == 2`}},
+	}
+
+	limiter := &countingLimiter{}
+	sink := &memorySink{}
+	after := 0
+	var stopErr error
+	stops := 0
+	runner := NewRunner(
+		WithRateLimiter(limiter),
+		WithSink(sink),
+		WithHooks(Hooks{
+			AfterResponse: func(ctx context.Context, res *Result) { after++ },
+			OnStop: func(ctx context.Context, tr *TemplateRequest, err error) {
+				stops++
+				stopErr = err
+			},
+		}),
+	)
+
+	results := []*Result{}
+	for res := range runner.All(context.Background(), tr, nil) {
+		results = append(results, res)
+	}
+
+	if len(results) != 3 {
+		t.Fatalf("Expected 3 results, got %d", len(results))
+	}
+	for i, res := range results {
+		if res.Err != nil {
+			t.Fatalf("Expected no error, got %v", res.Err)
+		}
+		if res.Template != "pages" || res.Iteration != i || res.Page != i+1 {
+			t.Errorf("Expected iteration %d of pages, got %+v", i, res)
+		}
+		if res.Reported != (i != 1) {
+			t.Errorf("Expected page %d reported to be %v", res.Page, i != 1)
+		}
+		if res.Continue != (i < 2) {
+			t.Errorf("Expected page %d continue to be %v", res.Page, i < 2)
+		}
+	}
+	if results[0].Response.RawBody != `{"page": 1}` {
+		t.Errorf("Expected results to keep their own response, got %s", results[0].Response.RawBody)
+	}
+
+	if got := strings.Join(sink.bodies, ","); got != `1:{"page": 1},3:{"page": 3}` {
+		t.Errorf("Expected the sink to get reported responses, got %s", got)
+	}
+	if limiter.waits.Load() != 3 {
+		t.Errorf("Expected 3 waits on the limiter, got %d", limiter.waits.Load())
+	}
+	if after != 3 {
+		t.Errorf("Expected AfterResponse for every result, got %d", after)
+	}
+	if stops != 1 || stopErr != nil {
+		t.Errorf("Expected one OnStop without error, got %d %v", stops, stopErr)
+	}
+}
+
+func TestRunnerStopEarly(t *testing.T) {
+	RegisterTransport("echo-runner-stop", func(tr *TemplateRequest) (Transport, error) { return &echoTransport{}, nil })
+
+	tr := &TemplateRequest{
+		URL:           "echo-runner-stop://local",
+		Body:          `{{.Page}}`,
+		MaxIterations: 10,
+	}
+
+	var stopErr error
+	runner := NewRunner(WithHooks(Hooks{
+		OnStop: func(ctx context.Context, tr *TemplateRequest, err error) { stopErr = err },
+	}))
+
+	count := 0
+	for range runner.All(context.Background(), tr, nil) {
+		count++
+		if count == 2 {
+			break
+		}
+	}
+
+	if count != 2 {
+		t.Errorf("Expected 2 results, got %d", count)
+	}
+	if !errors.Is(stopErr, ErrStopped) {
+		t.Errorf("Expected ErrStopped, got %v", stopErr)
+	}
+}
+
+type failingTransport struct{}
+
+func (t *failingTransport) RoundTrip(ctx context.Context, c *RequestContext, req *RenderedRequest) (*SimpleResponse, error) {
+	if c.Page == 2 {
+		return nil, errors.New("connection refused")
+	}
+	return &SimpleResponse{Status: 200, Body: req.Body}, nil
+}
+
+func (t *failingTransport) Close() error { return nil }
+
+func TestRunnerError(t *testing.T) {
+	RegisterTransport("failing", func(tr *TemplateRequest) (Transport, error) { return &failingTransport{}, nil })
+
+	tr := &TemplateRequest{
+		URL:           "failing://local",
+		Body:          `{{.Page}}`,
+		MaxIterations: 5,
+	}
+
+	results := []*Result{}
+	for res := range NewRunner().Run(context.Background(), tr, nil) {
+		results = append(results, res)
+	}
+
+	if len(results) != 2 {
+		t.Fatalf("Expected 2 results, got %d", len(results))
+	}
+	if results[0].Err != nil {
+		t.Errorf("Expected no error on the first page, got %v", results[0].Err)
+	}
+	if results[1].Err == nil || results[1].Page != 2 {
+		t.Errorf("Expected the error of page 2 as last result, got %+v", results[1])
+	}
+}
+
+func TestRecurseReturnsError(t *testing.T) {
+	RegisterTransport("failing-recurse", func(tr *TemplateRequest) (Transport, error) { return &failingTransport{}, nil })
+
+	tr := &TemplateRequest{
+		URL:           "failing-recurse://local",
+		Body:          `{{.Page}}`,
+		MaxIterations: 5,
+	}
+
+	bodies := 0
+	err := tr.Recurse(&RequestContext{}, func(body []byte) { bodies++ })
+	if err == nil {
+		t.Error("Expected the error that ended the run")
+	}
+	if bodies != 1 {
+		t.Errorf("Expected one body before the error, got %d", bodies)
+	}
+}
+
+type blockingTransport struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (t *blockingTransport) RoundTrip(ctx context.Context, c *RequestContext, req *RenderedRequest) (*SimpleResponse, error) {
+	close(t.started)
+	<-t.release
+	return &SimpleResponse{Status: 200, Body: req.Body}, nil
+}
+
+func (t *blockingTransport) Close() error { return nil }
+
+func TestRunnerTemplateRunning(t *testing.T) {
+	blocking := &blockingTransport{started: make(chan struct{}), release: make(chan struct{})}
+	RegisterTransport("blocking", func(tr *TemplateRequest) (Transport, error) { return blocking, nil })
+
+	tr := &TemplateRequest{URL: "blocking://local", MaxIterations: 1}
+	runner := NewRunner()
+
+	first := runner.Run(context.Background(), tr, nil)
+	<-blocking.started
+
+	second := []*Result{}
+	for res := range runner.Run(context.Background(), tr, nil) {
+		second = append(second, res)
+	}
+	close(blocking.release)
+
+	if len(second) != 1 || !errors.Is(second[0].Err, ErrTemplateRunning) {
+		t.Errorf("Expected ErrTemplateRunning, got %v", second)
+	}
+	for res := range first {
+		if res.Err != nil {
+			t.Errorf("Expected the first run to finish, got %v", res.Err)
+		}
+	}
+}
+
+func TestRunnerConcurrentTemplates(t *testing.T) {
+	RegisterTransport("echo-concurrent", func(tr *TemplateRequest) (Transport, error) { return &echoTransport{}, nil })
+
+	limiter := &countingLimiter{}
+	sink := &memorySink{}
+	runner := NewRunner(WithRateLimiter(limiter), WithSink(sink), WithBuffer(1))
+
+	var wg sync.WaitGroup
+	counts := make([]int, 4)
+	for i := range counts {
+		tr := &TemplateRequest{
+			URL:           "echo-concurrent://local",
+			Body:          fmt.Sprintf(`%d-{{.Page}}`, i),
+			MaxIterations: 5,
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range runner.Run(context.Background(), tr, nil) {
+				counts[i]++
+			}
+		}()
+	}
+	wg.Wait()
+
+	for i, count := range counts {
+		if count != 5 {
+			t.Errorf("Expected 5 results for template %d, got %d", i, count)
+		}
+	}
+	if len(sink.bodies) != 20 || limiter.waits.Load() != 20 {
+		t.Errorf("Expected 20 sink writes and waits, got %d and %d", len(sink.bodies), limiter.waits.Load())
+	}
+}
+
+func TestRunnerClientAndLogger(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Header.Get("X-Client")))
+	}))
+	defer server.Close()
+
+	client := &http.Client{Transport: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		req.Header.Set("X-Client", "custom")
+		return http.DefaultTransport.RoundTrip(req)
+	})}
+
+	var logs bytes.Buffer
+	tr := &TemplateRequest{
+		URL:           server.URL,
+		Method:        "GET",
+		StopOnRepeat:  true,
+		MaxIterations: 10,
+	}
+
+	bodies := []string{}
+	runner := NewRunner(WithClient(client), WithLogger(log.New(&logs, "", 0)))
+	for res := range runner.All(context.Background(), tr, nil) {
+		bodies = append(bodies, string(res.Body))
+	}
+
+	if len(bodies) != 2 || bodies[0] != "custom" {
+		t.Errorf("Expected responses sent with the runner's client, got %v", bodies)
+	}
+	if !strings.Contains(logs.String(), "response body repeated") {
+		t.Errorf("Expected the template to log to the runner's logger, got %q", logs.String())
+	}
+	if tr.client != nil {
+		t.Error("Expected the template not to build a client of its own")
+	}
+}
+
+func TestRunnerRequestHooks(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Signature-Seen", r.Header.Get("X-Signature"))
+		w.Write([]byte(`{"ok": true}`))
+	}))
+	defer server.Close()
+
+	tr := &TemplateRequest{
+		URL:           server.URL + "/{{.Page}}",
+		Method:        "GET",
+		MaxIterations: 2,
+	}
+
+	runner := NewRunner(WithHooks(Hooks{
+		BeforeRequest: func(ctx context.Context, c *RequestContext, req *http.Request) error {
+			req.Header.Set("X-Signature", fmt.Sprintf("sig-%s", req.URL.Path))
+			if c.Page == 2 {
+				return errors.New("signing failed")
+			}
+			return nil
+		},
+	}))
+
+	results := []*Result{}
+	for res := range runner.All(context.Background(), tr, nil) {
+		results = append(results, res)
+	}
+
+	if len(results) != 2 {
+		t.Fatalf("Expected 2 results, got %d", len(results))
+	}
+	first := results[0]
+	if first.HTTPResponse == nil || first.HTTPResponse.Header.Get("X-Signature-Seen") != "sig-/1" {
+		t.Errorf("Expected the signed request and the HTTP response, got %+v", first.HTTPResponse)
+	}
+	if first.Response.BodyObject.(map[string]any)["ok"] != true {
+		t.Errorf("Expected the parsed response, got %v", first.Response.BodyObject)
+	}
+	if results[1].Err == nil || results[1].Err.Error() != "signing failed" {
+		t.Errorf("Expected the hook error to fail the request, got %v", results[1].Err)
+	}
+}
@@ -0,0 +1,56 @@
+package request
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Timeouts bound each phase of a request. Zero values mean no limit.
+//
+//	timeouts:
+//	  connect: 5s
+//	  tls_handshake: 5s
+//	  response_header: 10s
+//	  request: 30s
+//	  ws_read: 10s
+type Timeouts struct {
+	// Connect limits establishing the TCP connection.
+	Connect Duration `yaml:"connect"`
+	// TLSHandshake limits the TLS handshake.
+	TLSHandshake Duration `yaml:"tls_handshake"`
+	// ResponseHeader limits the wait for response headers once the request
+	// is written.
+	ResponseHeader Duration `yaml:"response_header"`
+	// Request limits the whole request, reading the body included.
+	Request Duration `yaml:"request"`
+	// WSRead limits the wait for each WebSocket reply.
+	WSRead Duration `yaml:"ws_read"`
+}
+
+// Duration is a time.Duration written in YAML as "500ms", "10s" or "1m".
+type Duration time.Duration
+
+func (d *Duration) UnmarshalYAML(value *yaml.Node) error {
+	parsed, err := time.ParseDuration(value.Value)
+	if err != nil {
+		return fmt.Errorf("invalid duration %q: %w", value.Value, err)
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// deadline returns when a wait of d that starts now has to end, capped by
+// the context deadline. The zero time means no deadline.
+func deadline(ctx context.Context, d Duration) time.Time {
+	var t time.Time
+	if d > 0 {
+		t = time.Now().Add(time.Duration(d))
+	}
+	if ctxDeadline, ok := ctx.Deadline(); ok && (t.IsZero() || ctxDeadline.Before(t)) {
+		t = ctxDeadline
+	}
+	return t
+}
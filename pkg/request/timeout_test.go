@@ -0,0 +1,116 @@
+package request
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestTimeoutsYAML(t *testing.T) {
+	tr, err := FromBytes([]byte("url: http://example.com\ntimeouts:\n  connect: 2s\n  response_header: 500ms\n  ws_read: 1m\n"))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if tr.Timeouts.Connect != Duration(2*time.Second) || tr.Timeouts.ResponseHeader != Duration(500*time.Millisecond) || tr.Timeouts.WSRead != Duration(time.Minute) {
+		t.Errorf("Expected timeouts to be parsed, got %+v", tr.Timeouts)
+	}
+
+	if _, err := FromBytes([]byte("url: http://example.com\ntimeouts:\n  request: soon\n")); err == nil {
+		t.Error("Expected error for invalid duration")
+	}
+}
+
+func slowServer(delay time.Duration) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+		}
+		w.Write([]byte(`{}`))
+	}))
+}
+
+func TestHTTPTimeouts(t *testing.T) {
+	server := slowServer(time.Second)
+	defer server.Close()
+
+	for name, timeouts := range map[string]Timeouts{
+		"request":         {Request: Duration(50 * time.Millisecond)},
+		"response header": {ResponseHeader: Duration(50 * time.Millisecond)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			tr := &TemplateRequest{Method: "GET", URL: server.URL, Timeouts: timeouts}
+
+			start := time.Now()
+			if _, _, err := tr.Send(&RequestContext{}); err == nil {
+				t.Error("Expected timeout error")
+			}
+			if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+				t.Errorf("Expected the request to time out quickly, took %s", elapsed)
+			}
+		})
+	}
+}
+
+func TestWebSocketReadTimeout(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		// never reply
+		conn.ReadMessage()
+		time.Sleep(time.Second)
+	}))
+	defer server.Close()
+
+	tr := &TemplateRequest{
+		URL:      "ws" + strings.TrimPrefix(server.URL, "http"),
+		Body:     "ping",
+		Timeouts: Timeouts{WSRead: Duration(50 * time.Millisecond)},
+	}
+	defer tr.Close()
+
+	if _, _, err := tr.Send(&RequestContext{}); err == nil {
+		t.Error("Expected read timeout error")
+	}
+}
+
+func TestRecurseContextDeadline(t *testing.T) {
+	server := slowServer(20 * time.Millisecond)
+	defer server.Close()
+
+	checkpoint := filepath.Join(t.TempDir(), "run.checkpoint")
+	tr := &TemplateRequest{
+		Method:         "GET",
+		URL:            server.URL,
+		MaxIterations:  1000,
+		CheckpointFile: checkpoint,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 150*time.Millisecond)
+	defer cancel()
+
+	responses := 0
+	tr.RecurseContext(ctx, &RequestContext{}, func(body []byte) { responses++ })
+
+	if responses == 0 || responses >= 1000 {
+		t.Errorf("Expected the run to stop part way, got %d responses", responses)
+	}
+
+	cp, err := LoadCheckpoint(checkpoint)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if cp.Done || cp.Iteration != responses {
+		t.Errorf("Expected checkpoint to resume at %d, got %d (done %v)", responses, cp.Iteration, cp.Done)
+	}
+}
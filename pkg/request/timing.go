@@ -0,0 +1,78 @@
+package request
+
+import (
+	"crypto/tls"
+	"net/http/httptrace"
+	"time"
+)
+
+// Timing breaks down where the time of a request went, in milliseconds.
+// Phases that didn't happen, like DNS and connect on a reused connection,
+// are zero. With redirects the phases are those of the last hop while
+// TTFB and Total count from the first request.
+type Timing struct {
+	DNS     float64 `json:"dns_ms"`
+	Connect float64 `json:"connect_ms"`
+	TLS     float64 `json:"tls_ms"`
+	// TTFB is the time until the first byte of the response arrived.
+	TTFB float64 `json:"ttfb_ms"`
+	// Total includes reading the body.
+	Total float64 `json:"total_ms"`
+	// Reused is set when the request went over a kept-alive connection.
+	Reused bool `json:"reused"`
+}
+
+// requestTrace collects the timestamps of one request through httptrace.
+type requestTrace struct {
+	start time.Time
+
+	dnsStart, dnsDone         time.Time
+	connectStart, connectDone time.Time
+	tlsStart, tlsDone         time.Time
+	firstByte                 time.Time
+	reused                    bool
+	remoteAddr                string
+}
+
+func newRequestTrace() *requestTrace {
+	return &requestTrace{start: time.Now()}
+}
+
+func (rt *requestTrace) clientTrace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		DNSStart:     func(httptrace.DNSStartInfo) { rt.dnsStart = time.Now() },
+		DNSDone:      func(httptrace.DNSDoneInfo) { rt.dnsDone = time.Now() },
+		ConnectStart: func(string, string) { rt.connectStart = time.Now() },
+		ConnectDone:  func(string, string, error) { rt.connectDone = time.Now() },
+		TLSHandshakeStart: func() {
+			rt.tlsStart = time.Now()
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			rt.tlsDone = time.Now()
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			rt.reused = info.Reused
+			rt.remoteAddr = info.Conn.RemoteAddr().String()
+		},
+		GotFirstResponseByte: func() { rt.firstByte = time.Now() },
+	}
+}
+
+// timing returns the phases of a request that finished at end.
+func (rt *requestTrace) timing(end time.Time) Timing {
+	return Timing{
+		DNS:     milliseconds(rt.dnsStart, rt.dnsDone),
+		Connect: milliseconds(rt.connectStart, rt.connectDone),
+		TLS:     milliseconds(rt.tlsStart, rt.tlsDone),
+		TTFB:    milliseconds(rt.start, rt.firstByte),
+		Total:   milliseconds(rt.start, end),
+		Reused:  rt.reused,
+	}
+}
+
+func milliseconds(start, end time.Time) float64 {
+	if start.IsZero() || end.IsZero() || end.Before(start) {
+		return 0
+	}
+	return float64(end.Sub(start).Microseconds()) / 1000
+}
@@ -0,0 +1,85 @@
+package request
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestHTTPTiming(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(50 * time.Millisecond)
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	tr := &TemplateRequest{
+		Method:   "GET",
+		URL:      server.URL,
+		StopWhen: []string{`select(.timing.ttfb_ms >= 50 and .timing.reused) | .`},
+	}
+
+	_, shouldContinue, err := tr.Send(&RequestContext{})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	timing := tr.LastResponse.Timing
+	if timing.TTFB < 50 || timing.Total < timing.TTFB {
+		t.Errorf("Expected ttfb of at least 50ms and total of at least ttfb, got %+v", timing)
+	}
+	if timing.Reused || timing.Connect == 0 {
+		t.Errorf("Expected the first request to open a connection, got %+v", timing)
+	}
+	if !shouldContinue {
+		t.Error("Expected the first request not to match the reused condition")
+	}
+
+	_, shouldContinue, err = tr.Send(&RequestContext{})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !tr.LastResponse.Timing.Reused || tr.LastResponse.Timing.Connect != 0 {
+		t.Errorf("Expected the second request to reuse the connection, got %+v", tr.LastResponse.Timing)
+	}
+	if shouldContinue {
+		t.Error("Expected stop_when to see the timing")
+	}
+}
+
+func TestWebSocketTiming(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			_, msg, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			time.Sleep(20 * time.Millisecond)
+			conn.WriteMessage(websocket.TextMessage, msg)
+		}
+	}))
+	defer server.Close()
+
+	tr := &TemplateRequest{URL: "ws" + strings.TrimPrefix(server.URL, "http"), Body: "{}"}
+	defer tr.Close()
+
+	for i := 0; i < 2; i++ {
+		if _, _, err := tr.Send(&RequestContext{Iteration: i}); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+	}
+
+	timing := tr.LastResponse.Timing
+	if timing.Total < 20 || timing.TTFB != timing.Total || !timing.Reused {
+		t.Errorf("Expected a round trip of at least 20ms over the open connection, got %+v", timing)
+	}
+}
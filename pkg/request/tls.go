@@ -0,0 +1,101 @@
+package request
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// TLSConfig configures TLS for https and wss requests.
+//
+//	tls:
+//	  ca_file: certs/staging-ca.pem
+//	  cert_file: certs/client.pem
+//	  key_file: certs/client-key.pem
+type TLSConfig struct {
+	// InsecureSkipVerify accepts any server certificate.
+	InsecureSkipVerify bool `yaml:"insecure_skip_verify"`
+	// ServerName is the name certificates are verified against and sent
+	// with SNI, the host of the URL when empty.
+	ServerName string `yaml:"server_name"`
+	// CAFile holds PEM certificates trusted on top of the system ones.
+	CAFile string `yaml:"ca_file"`
+	// CertFile and KeyFile are a PEM client certificate and its key.
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+}
+
+// IsZero reports whether c changes nothing about the default TLS settings.
+func (c TLSConfig) IsZero() bool {
+	return c == TLSConfig{}
+}
+
+// Merge returns c with the fields set in over replacing its own.
+func (c TLSConfig) Merge(over TLSConfig) TLSConfig {
+	if over.InsecureSkipVerify {
+		c.InsecureSkipVerify = true
+	}
+	for _, field := range []struct {
+		dst *string
+		src string
+	}{
+		{&c.ServerName, over.ServerName},
+		{&c.CAFile, over.CAFile},
+		{&c.CertFile, over.CertFile},
+		{&c.KeyFile, over.KeyFile},
+	} {
+		if field.src != "" {
+			*field.dst = field.src
+		}
+	}
+	return c
+}
+
+// tlsConfig builds the TLS settings of the template, nil when it has none.
+func (tr *TemplateRequest) tlsConfig() (*tls.Config, error) {
+	c := tr.TLS
+	if tr.proxyURL != nil && !c.InsecureSkipVerify && c.CAFile == "" && c.ServerName == "" {
+		// intercepting proxies present their own certificates, trusting
+		// them takes their CA in ca_file
+		tr.logger().Println("TLS certificate verification is off behind the proxy, set tls ca_file to keep it on")
+		c.InsecureSkipVerify = true
+	}
+	if c.IsZero() {
+		return nil, nil
+	}
+
+	config := &tls.Config{
+		InsecureSkipVerify: c.InsecureSkipVerify,
+		ServerName:         c.ServerName,
+	}
+
+	if c.CAFile != "" {
+		pem, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("tls ca_file: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("tls ca_file: no certificates in %s", c.CAFile)
+		}
+		config.RootCAs = pool
+	}
+
+	if c.CertFile != "" || c.KeyFile != "" {
+		if c.CertFile == "" || c.KeyFile == "" {
+			return nil, errors.New("tls cert_file and key_file have to be set together")
+		}
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("tls client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return config, nil
+}
@@ -0,0 +1,92 @@
+package request
+
+import (
+	"bytes"
+	"encoding/pem"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTLSCAFile(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(caFile, cert, 0600); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	untrusted := &TemplateRequest{Method: "GET", URL: server.URL}
+	if _, _, err := untrusted.Send(&RequestContext{}); err == nil {
+		t.Error("Expected an error for an unknown certificate authority")
+	}
+
+	tr := &TemplateRequest{Method: "GET", URL: server.URL, TLS: TLSConfig{CAFile: caFile}}
+	if _, _, err := tr.Send(&RequestContext{}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	insecure := &TemplateRequest{Method: "GET", URL: server.URL, TLS: TLSConfig{InsecureSkipVerify: true}}
+	if _, _, err := insecure.Send(&RequestContext{}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+}
+
+func TestTLSErrors(t *testing.T) {
+	tests := map[string]TLSConfig{
+		"missing ca_file":   {CAFile: "missing.pem"},
+		"cert without key":  {CertFile: "client.pem"},
+		"missing cert pair": {CertFile: "client.pem", KeyFile: "client-key.pem"},
+	}
+	for name, config := range tests {
+		tr := &TemplateRequest{Method: "GET", URL: "https://localhost", TLS: config}
+		if _, _, err := tr.Send(&RequestContext{}); err == nil {
+			t.Errorf("Expected an error for %s", name)
+		}
+	}
+}
+
+func TestTLSMerge(t *testing.T) {
+	base := TLSConfig{ServerName: "template", CAFile: "template.pem"}
+	merged := base.Merge(TLSConfig{CAFile: "profile.pem", InsecureSkipVerify: true})
+
+	expected := TLSConfig{ServerName: "template", CAFile: "profile.pem", InsecureSkipVerify: true}
+	if merged != expected {
+		t.Errorf("Expected %+v, got %+v", expected, merged)
+	}
+}
+
+func TestTLSBehindProxy(t *testing.T) {
+	var logs bytes.Buffer
+	tr := &TemplateRequest{Logger: log.New(&logs, "", 0)}
+	if err := tr.SetProxy("http://127.0.0.1:8080"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	config, err := tr.tlsConfig()
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if config == nil || !config.InsecureSkipVerify {
+		t.Error("Expected verification to be off behind a proxy without a CA")
+	}
+	if !strings.Contains(logs.String(), "verification is off") {
+		t.Errorf("Expected skipping verification to be logged, got %q", logs.String())
+	}
+
+	tr.TLS = TLSConfig{ServerName: "api.internal"}
+	if config, err = tr.tlsConfig(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if config.InsecureSkipVerify {
+		t.Error("Expected verification to stay on with a configured server name")
+	}
+}
@@ -0,0 +1,126 @@
+package request
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// Transport sends a rendered request and returns the response. Send picks
+// the transport registered for the scheme of the rendered URL. RoundTrip
+// should give up once ctx is done.
+type Transport interface {
+	RoundTrip(ctx context.Context, c *RequestContext, req *RenderedRequest) (*SimpleResponse, error)
+	Close() error
+}
+
+// TransportFactory creates the transport a template uses for a scheme. It is
+// called once per template and scheme, so transports can keep connections
+// open between iterations.
+type TransportFactory func(tr *TemplateRequest) (Transport, error)
+
+// RenderedRequest is a template request with all templates executed.
+type RenderedRequest struct {
+	Method string
+	URL    *url.URL
+	Header http.Header
+	Body   []byte
+}
+
+var (
+	transportsMu sync.RWMutex
+	transports   = map[string]TransportFactory{
+		"http":  newHTTPTransport,
+		"https": newHTTPTransport,
+		"ws":    newWSTransport,
+		"wss":   newWSTransport,
+	}
+)
+
+// RegisterTransport makes a transport available for URLs with scheme,
+// replacing any transport already registered for it.
+func RegisterTransport(scheme string, factory TransportFactory) {
+	transportsMu.Lock()
+	defer transportsMu.Unlock()
+	transports[strings.ToLower(scheme)] = factory
+}
+
+func (tr *TemplateRequest) transport(scheme string) (Transport, error) {
+	scheme = strings.ToLower(scheme)
+	if t, ok := tr.openTransports[scheme]; ok {
+		return t, nil
+	}
+
+	transportsMu.RLock()
+	factory, ok := transports[scheme]
+	transportsMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("invalid request: no transport for scheme %q", scheme)
+	}
+
+	t, err := factory(tr)
+	if err != nil {
+		return nil, err
+	}
+
+	if tr.openTransports == nil {
+		tr.openTransports = map[string]Transport{}
+	}
+	tr.openTransports[scheme] = t
+	return t, nil
+}
+
+// Close closes every transport the template opened, such as WebSocket
+// connections.
+func (tr *TemplateRequest) Close() error {
+	var firstErr error
+	for scheme, t := range tr.openTransports {
+		if err := t.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		delete(tr.openTransports, scheme)
+	}
+	return firstErr
+}
+
+// Render executes the URL, header and body templates against c.
+func (tr *TemplateRequest) Render(c *RequestContext) (*RenderedRequest, error) {
+	// body and URL errors are ignored on purpose: on the first iteration
+	// references like .LastResponse.BodyObject.id fail and should render as
+	// whatever was written up to that point
+	var bodyBytes bytes.Buffer
+	tr.BodyTemplate().Execute(&bodyBytes, c)
+
+	var urlBytes bytes.Buffer
+	tr.URLTemplate().Execute(&urlBytes, c)
+
+	requestURL, err := url.Parse(urlBytes.String())
+	if err != nil {
+		return nil, err
+	}
+
+	httpHeader := http.Header{}
+	for _, headerTpl := range tr.HeaderTemplates() {
+		var hdrBytes bytes.Buffer
+		var valBytes bytes.Buffer
+		if err := headerTpl.HeaderTemplate.Execute(&hdrBytes, c); err != nil {
+			return nil, err
+		}
+		if err := headerTpl.ValueTemplate.Execute(&valBytes, c); err != nil {
+			return nil, err
+		}
+
+		httpHeader.Set(hdrBytes.String(), valBytes.String())
+	}
+
+	return &RenderedRequest{
+		Method: tr.Method,
+		URL:    requestURL,
+		Header: httpHeader,
+		Body:   bodyBytes.Bytes(),
+	}, nil
+}
@@ -0,0 +1,154 @@
+package request
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+type echoTransport struct {
+	closed bool
+}
+
+func (t *echoTransport) RoundTrip(ctx context.Context, c *RequestContext, req *RenderedRequest) (*SimpleResponse, error) {
+	return &SimpleResponse{Status: 200, Body: req.Body}, nil
+}
+
+func (t *echoTransport) Close() error {
+	t.closed = true
+	return nil
+}
+
+func TestRegisterTransport(t *testing.T) {
+	echo := &echoTransport{}
+	RegisterTransport("echo", func(tr *TemplateRequest) (Transport, error) { return echo, nil })
+
+	tr := &TemplateRequest{
+		URL:      "echo://local",
+		Body:     `{"page": {{.Page}}}`,
+		StopWhen: []string{`select(.body_object.page == 2) | .`},
+	}
+
+	bodies := []string{}
+	tr.Recurse(&RequestContext{}, func(body []byte) { bodies = append(bodies, string(body)) })
+
+	if len(bodies) != 2 || bodies[1] != `{"page": 2}` {
+		t.Errorf("Expected two echoed bodies, got %v", bodies)
+	}
+	if !echo.closed {
+		t.Error("Expected transport to be closed after the run")
+	}
+}
+
+func TestUnknownTransport(t *testing.T) {
+	tr := &TemplateRequest{URL: "gopher://local"}
+	if _, _, err := tr.Send(&RequestContext{}); err == nil {
+		t.Error("Expected error for unknown scheme")
+	}
+}
+
+func TestWebSocketLastResponse(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for i := 1; ; i++ {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+			conn.WriteMessage(websocket.TextMessage, []byte(fmt.Sprintf(`{"token": "t%d"}`, i)))
+		}
+	}))
+	defer server.Close()
+
+	tr := &TemplateRequest{
+		URL:           "ws" + strings.TrimPrefix(server.URL, "http") + "/ws",
+		Body:          `{"token": "{{.LastResponse.BodyObject.token}}"}`,
+		MaxIterations: 3,
+	}
+
+	c := &RequestContext{}
+	tr.Recurse(c, func(body []byte) {})
+
+	if tr.LastResponse.RawBody != `{"token": "t3"}` {
+		t.Errorf("Expected last WebSocket reply in LastResponse, got %q", tr.LastResponse.RawBody)
+	}
+	if tr.LastResponse.Request.Path != "/ws" {
+		t.Errorf("Expected request path /ws, got %q", tr.LastResponse.Request.Path)
+	}
+}
+
+func TestExtract(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cursor := r.URL.Query().Get("cursor")
+		next := map[string]string{"": "abc", "abc": "def", "def": ""}[cursor]
+		fmt.Fprintf(w, `{"next_cursor": %q}`, next)
+	}))
+	defer server.Close()
+
+	tr, err := FromBytes([]byte(`
+url: ` + server.URL + `/?cursor={{.Extra.cursor}}
+method: GET
+extract:
+  cursor: '.body_object.next_cursor'
+stop_when:
+  - 'select($extra.cursor == "") | .'
+`))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	c := &RequestContext{Extra: map[string]interface{}{"cursor": ""}}
+	pages := 0
+	tr.Recurse(c, func(body []byte) { pages++ })
+
+	if pages != 3 {
+		t.Errorf("Expected 3 pages following the cursor, got %d", pages)
+	}
+}
+
+func TestWebSocketMaxBodySize(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for i := 0; ; i++ {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+			conn.WriteMessage(websocket.TextMessage, []byte(fmt.Sprint(i)+strings.Repeat("a", 99)))
+		}
+	}))
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	tr := &TemplateRequest{URL: url, Body: "hi", MaxBodySize: 10}
+	for i := 0; i < 2; i++ {
+		if _, _, err := tr.Send(&RequestContext{Iteration: i}); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		expected := fmt.Sprint(i) + strings.Repeat("a", 9)
+		if tr.LastResponse.RawBody != expected || !tr.LastResponse.Truncated {
+			t.Errorf("Expected truncated reply %q, got %q (truncated %v)", expected, tr.LastResponse.RawBody, tr.LastResponse.Truncated)
+		}
+	}
+	tr.Close()
+
+	tr = &TemplateRequest{URL: url, Body: "hi", MaxBodySize: 10, OnOversize: OversizeAbort}
+	defer tr.Close()
+	if _, _, err := tr.Send(&RequestContext{}); !errors.Is(err, ErrBodyTooLarge) {
+		t.Errorf("Expected ErrBodyTooLarge, got %v", err)
+	}
+}
@@ -0,0 +1,181 @@
+package request
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// wsTransport sends each request as a text message over one connection that
+// stays open for the whole run, the reply is the response.
+type wsTransport struct {
+	tr   *TemplateRequest
+	conn *websocket.Conn
+	// handshake is the upgrade response of conn
+	handshake *http.Response
+}
+
+func newWSTransport(tr *TemplateRequest) (Transport, error) {
+	return &wsTransport{tr: tr}, nil
+}
+
+func (t *wsTransport) dial(ctx context.Context, rendered *RenderedRequest) (*websocket.Conn, error) {
+	if t.conn == nil {
+		//parsedProxy, err := url.Parse("http://127.0.0.1:8080")
+		//websocket.DefaultDialer.Proxy = http.ProxyURL(parsedProxy)
+		dialer := *websocket.DefaultDialer
+		if t.tr.CookieJar {
+			dialer.Jar = t.tr.cookieJar()
+		}
+
+		tlsConfig, err := t.tr.tlsConfig()
+		if err != nil {
+			return nil, err
+		}
+		if tlsConfig != nil {
+			dialer.TLSClientConfig = tlsConfig
+		}
+
+		timeouts := t.tr.Timeouts
+		if timeouts.Connect > 0 {
+			dialer.NetDialContext = (&net.Dialer{Timeout: time.Duration(timeouts.Connect)}).DialContext
+		}
+		if timeouts.TLSHandshake+timeouts.ResponseHeader > 0 {
+			// the websocket handshake is the TLS handshake plus the upgrade
+			// response
+			dialer.HandshakeTimeout = time.Duration(timeouts.TLSHandshake + timeouts.ResponseHeader)
+		}
+
+		ws, handshake, err := dialer.DialContext(ctx, rendered.URL.String(), rendered.Header)
+		if err != nil {
+			return nil, err
+		}
+		if t.tr.MaxBodySize > 0 && strings.EqualFold(t.tr.OnOversize, OversizeAbort) {
+			ws.SetReadLimit(int64(t.tr.MaxBodySize))
+		}
+		t.conn = ws
+		t.handshake = handshake
+	}
+	return t.conn, nil
+}
+
+func (t *wsTransport) RoundTrip(ctx context.Context, c *RequestContext, rendered *RenderedRequest) (*SimpleResponse, error) {
+	reused := t.conn != nil
+	ws, err := t.dial(ctx, rendered)
+	if err != nil {
+		return nil, err
+	}
+
+	if t.tr.Timeouts.Request > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(t.tr.Timeouts.Request))
+		defer cancel()
+	}
+	// reads don't take a context, a deadline unblocks them once it is done
+	stop := context.AfterFunc(ctx, func() { ws.SetReadDeadline(time.Now()) })
+	defer stop()
+
+	if c.Iteration == 0 && t.tr.SetupBody != "" {
+		// hack to test if this could be useful
+		if err := ws.WriteMessage(websocket.TextMessage, []byte(t.tr.SetupBody)); err != nil {
+			return nil, err
+		}
+
+		msg, _, err := t.read(ctx, ws)
+		if err != nil {
+			return nil, err
+		}
+		t.tr.logger().Println(string(msg))
+	}
+
+	start := time.Now()
+	if err := ws.WriteMessage(websocket.TextMessage, rendered.Body); err != nil {
+		return nil, err
+	}
+
+	msg, truncated, err := t.read(ctx, ws)
+	if err != nil {
+		return nil, err
+	}
+	rtt := milliseconds(start, time.Now())
+
+	return &SimpleResponse{
+		Request: SimpleRequest{
+			Path:  rendered.URL.Path,
+			Query: rendered.URL.Query(),
+		},
+		Body:       msg,
+		TimeMS:     time.Since(start).Milliseconds(),
+		RemoteAddr: ws.RemoteAddr().String(),
+		Truncated:  truncated,
+		Proto:      "websocket",
+		TLS:        newTLSInfo(t.handshake.TLS),
+		// a reply arrives in one piece, so the first byte is the round trip
+		Timing: Timing{TTFB: rtt, Total: rtt, Reused: reused},
+	}, nil
+}
+
+// read waits for the next message for at most the ws_read timeout. A
+// message over max_body_size is cut off and reported as truncated, or fails
+// with ErrBodyTooLarge when on_oversize is abort.
+func (t *wsTransport) read(ctx context.Context, ws *websocket.Conn) ([]byte, bool, error) {
+	if err := context.Cause(ctx); err != nil {
+		return nil, false, err
+	}
+	if err := ws.SetReadDeadline(deadline(ctx, t.tr.Timeouts.WSRead)); err != nil {
+		return nil, false, err
+	}
+
+	msg, truncated, err := t.readMessage(ws)
+	if err != nil {
+		if errors.Is(err, websocket.ErrReadLimit) {
+			err = fmt.Errorf("%w (%d bytes)", ErrBodyTooLarge, int64(t.tr.MaxBodySize))
+		} else if ctx.Err() != nil {
+			err = fmt.Errorf("%w: %w", context.Cause(ctx), err)
+		}
+		// gorilla connections are unusable after a failed read
+		t.Close()
+		return nil, false, err
+	}
+	return msg, truncated, nil
+}
+
+// readMessage reads the next message, keeping at most max_body_size bytes.
+// With on_oversize abort the read limit set when dialing fails the read
+// instead.
+func (t *wsTransport) readMessage(ws *websocket.Conn) ([]byte, bool, error) {
+	_, r, err := ws.NextReader()
+	if err != nil {
+		return nil, false, err
+	}
+
+	limit := int64(t.tr.MaxBodySize)
+	if limit <= 0 || strings.EqualFold(t.tr.OnOversize, OversizeAbort) {
+		msg, err := io.ReadAll(r)
+		return msg, false, err
+	}
+
+	msg, err := io.ReadAll(io.LimitReader(r, limit))
+	if err != nil {
+		return nil, false, err
+	}
+	// drop the rest so the next read starts at the next message
+	rest, err := io.Copy(io.Discard, r)
+	return msg, rest > 0, err
+}
+
+func (t *wsTransport) Close() error {
+	if t.conn == nil {
+		return nil
+	}
+	err := t.conn.Close()
+	t.conn = nil
+	return err
+}